@@ -0,0 +1,19 @@
+//go:build !windows && !linux && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// activeWindowSupported reports whether the focused window can be checked. macOS only shows window titles
+// to programs the user has allowed to record the screen, so keyboard-window is not supported there.
+func activeWindowSupported() error {
+	return fmt.Errorf("keyboard-window is not supported on %s", runtime.GOOS)
+}
+
+// activeWindowTitle returns the title of the window that has focus
+func activeWindowTitle() (string, error) {
+	return "", activeWindowSupported()
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetForegroundWindow = user32.NewProc("GetForegroundWindow")
+	procGetWindowText       = user32.NewProc("GetWindowTextW")
+)
+
+// activeWindowSupported reports whether the focused window can be checked
+func activeWindowSupported() error {
+	return nil
+}
+
+// activeWindowTitle returns the title of the window that has focus
+func activeWindowTitle() (string, error) {
+	hwnd, _, _ := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		return "", fmt.Errorf("no window has focus")
+	}
+
+	buf := make([]uint16, 512)
+	n, _, _ := procGetWindowText.Call(hwnd, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	return syscall.UTF16ToString(buf[:n]), nil
+}
@@ -0,0 +1,53 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// activeWindowSupported reports whether the focused window can be checked, which on X11 depends on the desktop
+func activeWindowSupported() error {
+	return nil
+}
+
+// activeWindowTitle returns the title of the window that has focus, asking the X11 window manager with xprop.
+// Wayland does not let programs see other windows, so the check only works on X11.
+func activeWindowTitle() (string, error) {
+	if os.Getenv("DISPLAY") == "" {
+		return "", fmt.Errorf("no X11 display, is DISPLAY set?")
+	}
+
+	// _NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007
+	out, err := exec.Command("xprop", "-root", "_NET_ACTIVE_WINDOW").Output()
+	if err != nil {
+		return "", fmt.Errorf("xprop: %v", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || !strings.HasPrefix(fields[len(fields)-1], "0x") {
+		return "", fmt.Errorf("window manager does not report the active window")
+	}
+	id := fields[len(fields)-1]
+	if id == "0x0" {
+		return "", fmt.Errorf("no window has focus")
+	}
+
+	// _NET_WM_NAME(UTF8_STRING) = "Show Cue Systems"
+	out, err = exec.Command("xprop", "-id", id, "_NET_WM_NAME").Output()
+	if err != nil {
+		return "", fmt.Errorf("xprop: %v", err)
+	}
+	_, value, ok := strings.Cut(string(out), "=")
+	if !ok {
+		return "", nil
+	}
+	title, err := strconv.Unquote(strings.TrimSpace(value))
+	if err != nil {
+		return strings.Trim(strings.TrimSpace(value), `"`), nil
+	}
+	return title, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultAllStopOSCAddress = "/osc-map/allstop"
+
+// hotkey is a key from KeyboardMap with any modifiers that must be held with it
+type hotkey struct {
+	name  string
+	key   int
+	ctrl  bool
+	alt   bool
+	shift bool
+}
+
+// parseHotkey reads a hotkey written as modifiers and a key joined by "+", e.g. "ctrl+shift+space".
+// The key may itself end in "+", as in "ctrl+num+".
+func parseHotkey(s string) (hotkey, error) {
+	hk := hotkey{name: s}
+
+	split := strings.LastIndex(strings.TrimSuffix(s, "+"), "+")
+	keyName := strings.TrimSpace(s[split+1:])
+
+	var modifiers []string
+	if split >= 0 {
+		modifiers = strings.Split(s[:split], "+")
+	}
+	for _, modifier := range modifiers {
+		switch strings.ToLower(strings.TrimSpace(modifier)) {
+		case "ctrl":
+			hk.ctrl = true
+		case "alt":
+			hk.alt = true
+		case "shift":
+			hk.shift = true
+		default:
+			return hk, fmt.Errorf("unknown modifier %q in %q", modifier, s)
+		}
+	}
+
+	key, ok := lookupKey(keyName)
+	if !ok {
+		return hk, fmt.Errorf("unknown key %q in %q", keyName, s)
+	}
+	hk.key = key
+
+	return hk, nil
+}
+
+// AllStop silences everything osc-map has started: audio is faded out and stopped, every house
+// light effect is cancelled and programs started with kill-on-all-stop are killed. If configured, the
+// house lights are handed back to the lightboard.
+func (m *OSCMap) AllStop() {
+	conf := m.settings().allStop
+	log.Warn("ALL STOP")
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		playbacks.stopAll(time.Duration(conf.Fade * float32(time.Second)))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.stopAllEffects(conf.ReleaseHouseLights)
+	}()
+
+	processes.killAll()
+
+	wg.Wait()
+	log.Info("All stop complete")
+}
+
+// listenForAllStopHotkey triggers an all stop whenever the configured hotkey is pressed
+func (m *OSCMap) listenForAllStopHotkey(s string) {
+	hk, err := parseHotkey(s)
+	if err != nil {
+		log.Errorf("Invalid all stop hotkey: %v", err)
+		return
+	}
+
+	err = listenForHotkey(hk, func() {
+		go m.AllStop()
+	})
+	if err != nil {
+		log.Errorf("Failed to listen for all stop hotkey %s: %v", s, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+	"github.com/faiface/beep/speaker"
+	"github.com/hypebeast/go-osc/osc"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultAudioBus       = "main"
+	DefaultStatusInterval = 1.0 // seconds between OSC status updates
+	DefaultFadeDepth      = 60  // decibels to fade audio down by before stopping it
+)
+
+// playback is a single audio file that is currently playing through the speaker
+type playback struct {
+	id    int
+	file  string
+	cue   string
+	bus   string
+	level float64
+
+	streamer beep.StreamSeekCloser
+	format   beep.Format
+	ctrl     *beep.Ctrl
+	volume   *effects.Volume
+}
+
+// PlaybackStatus is a snapshot of an active playback
+type PlaybackStatus struct {
+	ID        int     `json:"id"`
+	File      string  `json:"file"`
+	Cue       string  `json:"cue"`
+	Elapsed   float64 `json:"elapsed"`   // seconds
+	Remaining float64 `json:"remaining"` // seconds
+	Level     float64 `json:"level"`
+	Bus       string  `json:"bus"`
+}
+
+// playbackRegistry keeps track of every audio file that is playing so it can be reported and controlled
+type playbackRegistry struct {
+	mu     sync.Mutex
+	nextID int
+	active map[int]*playback
+}
+
+var playbacks = &playbackRegistry{active: make(map[int]*playback)}
+
+func (r *playbackRegistry) add(p *playback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	p.id = r.nextID
+	r.active[p.id] = p
+}
+
+func (r *playbackRegistry) remove(p *playback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.active, p.id)
+}
+
+// status returns the state of all active playbacks, oldest first
+func (r *playbackRegistry) status() []PlaybackStatus {
+	r.mu.Lock()
+	active := make([]*playback, 0, len(r.active))
+	for _, p := range r.active {
+		active = append(active, p)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].id < active[j].id
+	})
+
+	statuses := make([]PlaybackStatus, 0, len(active))
+
+	// the speaker must be locked while reading stream positions
+	speaker.Lock()
+	for _, p := range active {
+		position := p.streamer.Position()
+		length := p.streamer.Len()
+		statuses = append(statuses, PlaybackStatus{
+			ID:        p.id,
+			File:      p.file,
+			Cue:       p.cue,
+			Elapsed:   p.format.SampleRate.D(position).Seconds(),
+			Remaining: p.format.SampleRate.D(length - position).Seconds(),
+			Level:     p.level,
+			Bus:       p.bus,
+		})
+	}
+	speaker.Unlock()
+
+	return statuses
+}
+
+// stopAll fades out every active playback over the given duration and then stops it
+func (r *playbackRegistry) stopAll(fade time.Duration) {
+	r.mu.Lock()
+	active := make([]*playback, 0, len(r.active))
+	for _, p := range r.active {
+		active = append(active, p)
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range active {
+		wg.Add(1)
+		go func(p *playback) {
+			defer wg.Done()
+			p.fadeOut(fade)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// stop fades out a single playback over the given duration and then stops it, reporting whether it was playing
+func (r *playbackRegistry) stop(id int, fade time.Duration) bool {
+	r.mu.Lock()
+	p, ok := r.active[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	p.fadeOut(fade)
+	return true
+}
+
+// fadeOut lowers the playback level by DefaultFadeDepth decibels over the duration, then stops it
+func (p *playback) fadeOut(fade time.Duration) {
+	const step = 50 * time.Millisecond
+
+	if fade > 0 {
+		steps := int(fade / step)
+		for i := 1; i <= steps; i++ {
+			speaker.Lock()
+			p.volume.Volume = (p.level - DefaultFadeDepth*float64(i)/float64(steps)) / 20
+			speaker.Unlock()
+			time.Sleep(step)
+		}
+	}
+
+	// a Ctrl without a streamer is drained, which lets playAudioFile return
+	speaker.Lock()
+	p.volume.Silent = true
+	p.ctrl.Streamer = nil
+	speaker.Unlock()
+}
+
+// PlaybackStatus returns the state of all audio files that are currently playing
+func (m *OSCMap) PlaybackStatus() []PlaybackStatus {
+	return playbacks.status()
+}
+
+// formatCountdown formats a number of seconds as m:ss for display on the operator's tablet
+func formatCountdown(remaining float64) string {
+	seconds := int(math.Round(remaining))
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// sendPlaybackStatus sends the state of every active playback to the OSC status client.
+//
+// /osc-map/audio/count <int> is sent first, followed by one /osc-map/audio/<n> message per playback
+// with the arguments file, cue, elapsed seconds, remaining seconds, remaining countdown, level in dB and bus.
+func (m *OSCMap) sendPlaybackStatus() {
+	if m.oscStatusClient == nil {
+		return
+	}
+
+	statuses := playbacks.status()
+
+	err := m.oscStatusClient.Send(osc.NewMessage("/osc-map/audio/count", int32(len(statuses))))
+	if err != nil {
+		log.Errorf("Failed to send audio status: %v", err)
+		return
+	}
+
+	for i, s := range statuses {
+		msg := osc.NewMessage(fmt.Sprintf("/osc-map/audio/%d", i+1),
+			s.File,
+			s.Cue,
+			float32(s.Elapsed),
+			float32(s.Remaining),
+			formatCountdown(s.Remaining),
+			float32(s.Level),
+			s.Bus)
+
+		err := m.oscStatusClient.Send(msg)
+		if err != nil {
+			log.Errorf("Failed to send audio status: %v", err)
+			return
+		}
+	}
+}
+
+// reportPlaybackStatus periodically sends the audio status until the program exits
+func (m *OSCMap) reportPlaybackStatus(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.sendPlaybackStatus()
+	}
+}
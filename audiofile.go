@@ -1,82 +1,307 @@
 package main
 
 import (
+	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
 	"github.com/faiface/beep/mp3"
 	"github.com/faiface/beep/speaker"
 	"github.com/faiface/beep/wav"
 	log "github.com/sirupsen/logrus"
 )
 
-// play a simple audio file with no fading or level change
-func (m *OSCMap) playAudioFile(cueNumber string, cueInteger string) {
-	mc, ok := m.controlMap[cueNumber]
-	if !ok {
-		mc, ok = m.controlMap[cueInteger]
-		if !ok {
-			log.Debugf("No audio file for cue[%v]", cueNumber)
-			return
-		}
-	}
+const DefaultResampleCacheDir = "resample-cache"
 
-	filename := mc.audioFile
+// audioSettings holds the playback options from outputs.audio with defaults applied
+type audioSettings struct {
+	sampleRate      beep.SampleRate
+	bufferSize      int
+	resampleQuality int
+	cacheEnabled    bool
+	cacheDir        string
 
-	if filename == "" {
-		log.Debugf("Did not find audio file for cue[%v]", cueNumber)
-		return
+	// cache is shared by every reload, so copies already made are not checked again
+	cache *resampleCache
+}
+
+// resampleCache maps an original file path to its pre-resampled copy. It is filled in the background
+// while cues are playing.
+type resampleCache struct {
+	mutex sync.Mutex
+	files map[string]string
+}
+
+func newResampleCache() *resampleCache {
+	return &resampleCache{files: make(map[string]string)}
+}
+
+func (c *resampleCache) get(filename string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.files[filename]
+	return cached, ok
+}
+
+func (c *resampleCache) set(filename string, cached string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.files[filename] = cached
+}
+
+// newAudioSettings fills in any audio options missing from the config with the defaults. A relative cache-dir
+// is kept next to the config file in configDir, so every show has its own cache.
+func newAudioSettings(c confOutputAudio, configDir string) (audioSettings, error) {
+	settings := audioSettings{
+		sampleRate:      DefaultSampleRate,
+		bufferSize:      DefaultBufferSize,
+		resampleQuality: DefaultResampleQuality,
+		cacheEnabled:    c.ResampleCache,
+		cacheDir:        c.CacheDir,
+		cache:           newResampleCache(),
+	}
+
+	if c.SampleRate != 0 {
+		if c.SampleRate < 0 {
+			return settings, fmt.Errorf("invalid sample rate %d", c.SampleRate)
+		}
+		settings.sampleRate = beep.SampleRate(c.SampleRate)
+	}
+	if c.BufferSize != 0 {
+		if c.BufferSize < 0 {
+			return settings, fmt.Errorf("invalid buffer size %d", c.BufferSize)
+		}
+		settings.bufferSize = c.BufferSize
+	}
+	if c.ResampleQuality != 0 {
+		// beep panics outside of this range
+		if c.ResampleQuality < 1 || c.ResampleQuality > 64 {
+			return settings, fmt.Errorf("resample quality must be between 1 and 64, got %d", c.ResampleQuality)
+		}
+		settings.resampleQuality = c.ResampleQuality
 	}
+	if settings.cacheDir == "" {
+		settings.cacheDir = DefaultResampleCacheDir
+	}
+	if !filepath.IsAbs(settings.cacheDir) {
+		settings.cacheDir = filepath.Join(configDir, settings.cacheDir)
+	}
+
+	return settings, nil
+}
 
-	fileExtension := filepath.Ext(filename)
+// decodeAudioFile opens an mp3 or wav file and returns its decoded stream
+func decodeAudioFile(filename string) (beep.StreamSeekCloser, beep.Format, error) {
+	fileExtension := strings.ToLower(filepath.Ext(filename))
 
 	if fileExtension != ".mp3" && fileExtension != ".wav" {
-		log.Errorf("Incompatible file extension: %s", filename)
-		return
+		return nil, beep.Format{}, fmt.Errorf("incompatible file extension: %s", filename)
 	}
 
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Errorf("Cannot open file %s: %v", filename, err)
-		return
+		return nil, beep.Format{}, fmt.Errorf("cannot open file %s: %v", filename, err)
 	}
 
+	var streamer beep.StreamSeekCloser
+	var format beep.Format
 	if fileExtension == ".mp3" {
-		streamer, format, err := mp3.Decode(file)
+		streamer, format, err = mp3.Decode(file)
+	} else {
+		streamer, format, err = wav.Decode(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, beep.Format{}, fmt.Errorf("cannot decode file %s: %v", filename, err)
+	}
+
+	return streamer, format, nil
+}
+
+// checkFiles reports every cue file that does not match the output sample rate, and builds the
+// pre-resampled cache for them if it is enabled. Files that already have an up to date copy are skipped.
+// It decodes every file, so it is run in the background once a config has been accepted.
+func (a *audioSettings) checkFiles(controlMap map[string]cueMap) {
+	checked := make(map[string]bool)
+	for cueNumber, mc := range controlMap {
+		filename := mc.audioFile
+		if filename == "" || checked[filename] {
+			continue
+		}
+		checked[filename] = true
+
+		if cached, ok := a.cache.get(filename); ok && cached == a.cachePath(filename) && cacheFresh(filename, cached) {
+			continue
+		}
+
+		streamer, format, err := decodeAudioFile(filename)
 		if err != nil {
-			log.Errorf("Cannot decode file %s: %v", filename, err)
-			return
+			log.Errorf("Audio file for cue[%v] is not playable: %v", cueNumber, err)
+			continue
 		}
-		defer streamer.Close()
+		streamer.Close()
 
-		// buffer size of 1/10 of a second
-		resampled := beep.Resample(DefaultResampleQuality, DefaultSampleRate, format.SampleRate, streamer)
+		if format.SampleRate == a.sampleRate {
+			continue
+		}
 
-		done := make(chan bool)
-		speaker.Play(beep.Seq(resampled, beep.Callback(func() {
-			done <- true
-		})))
+		if !a.cacheEnabled {
+			log.Warnf("Audio file %s is %d Hz and will be resampled to %d Hz on every playback", filename, format.SampleRate, a.sampleRate)
+			continue
+		}
 
-		<-done
+		cached, err := a.resampleToCache(filename)
+		if err != nil {
+			log.Errorf("Failed to cache resampled copy of %s: %v", filename, err)
+			continue
+		}
+		log.Infof("Audio file %s is %d Hz, using resampled copy %s", filename, format.SampleRate, cached)
+		a.cache.set(filename, cached)
 	}
+}
 
-	if fileExtension == ".wav" {
-		streamer, format, err := wav.Decode(file)
-		if err != nil {
-			log.Errorf("Cannot decode file %s: %v", filename, err)
-			return
+// cachePath names the resampled copy of a file so that different sample rates, qualities and
+// source directories never collide
+func (a *audioSettings) cachePath(filename string) string {
+	h := fnv.New32a()
+	h.Write([]byte(filename))
+
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	name := fmt.Sprintf("%s.%08x.%d.q%d.wav", base, h.Sum32(), a.sampleRate, a.resampleQuality)
+	return filepath.Join(a.cacheDir, name)
+}
+
+// resampleToCache writes a wav copy of the file at the output sample rate, reusing an existing copy
+// if it is newer than the original
+func (a *audioSettings) resampleToCache(filename string) (string, error) {
+	cached := a.cachePath(filename)
+
+	if _, err := os.Stat(filename); err != nil {
+		return "", err
+	}
+	if cacheFresh(filename, cached) {
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(a.cacheDir, 0o755); err != nil {
+		return "", err
+	}
+
+	streamer, format, err := decodeAudioFile(filename)
+	if err != nil {
+		return "", err
+	}
+	defer streamer.Close()
+
+	// write to a temporary file first so a half-written cache is never played
+	tmp, err := os.CreateTemp(a.cacheDir, "resample-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	resampled := beep.Resample(a.resampleQuality, format.SampleRate, a.sampleRate, streamer)
+	format.SampleRate = a.sampleRate
+	err = wav.Encode(tmp, resampled, format)
+	tmp.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", err
+	}
+
+	return cached, nil
+}
+
+// cacheFresh reports whether a resampled copy exists and is newer than its original
+func cacheFresh(filename string, cached string) bool {
+	original, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(cached)
+	return err == nil && info.ModTime().After(original.ModTime())
+}
+
+// play a simple audio file at the cue's level with no fading
+func (m *OSCMap) playAudioFile(cueNumber string, cueInteger string) error {
+	if m.speakerSampleRate == 0 {
+		return cueError("Cannot play audio for cue[%v], audio-files is off", cueNumber)
+	}
+
+	settings := m.settings()
+	mc, ok := settings.controlMap[cueNumber]
+	if !ok {
+		mc, ok = settings.controlMap[cueInteger]
+		if !ok {
+			log.Debugf("No audio file for cue[%v]", cueNumber)
+			return nil
 		}
-		defer streamer.Close()
+	}
+
+	filename := mc.audioFile
+
+	if filename == "" {
+		log.Debugf("Did not find audio file for cue[%v]", cueNumber)
+		return nil
+	}
+
+	source := filename
+	if cached, ok := settings.audio.cache.get(filename); ok && settings.audio.cacheEnabled {
+		source = cached
+	}
+
+	streamer, format, err := decodeAudioFile(source)
+	if err != nil {
+		return cueError("%v", err)
+	}
+	defer streamer.Close()
 
-		// buffer size of 1/10 of a second
-		resampled := beep.Resample(DefaultResampleQuality, DefaultSampleRate, format.SampleRate, streamer)
+	var resampled beep.Streamer = streamer
+	if format.SampleRate != m.speakerSampleRate {
+		resampled = beep.Resample(settings.audio.resampleQuality, format.SampleRate, m.speakerSampleRate, streamer)
+	}
 
-		done := make(chan bool)
-		speaker.Play(beep.Seq(resampled, beep.Callback(func() {
-			done <- true
-		})))
+	bus := mc.audioBus
+	if bus == "" {
+		bus = DefaultAudioBus
+	}
 
-		<-done
+	// volume is set in decibels
+	volume := &effects.Volume{
+		Streamer: resampled,
+		Base:     10,
+		Volume:   mc.audioLevel / 20,
+	}
+	p := &playback{
+		file:     filename,
+		cue:      cueNumber,
+		bus:      bus,
+		level:    mc.audioLevel,
+		streamer: streamer,
+		format:   format,
+		ctrl:     &beep.Ctrl{Streamer: volume},
+		volume:   volume,
 	}
+	playbacks.add(p)
+	defer playbacks.remove(p)
+
+	log.Infof("Playing %s for cue[%v] on bus %s", filename, cueNumber, bus)
+
+	done := make(chan bool)
+	speaker.Play(beep.Seq(p.ctrl, beep.Callback(func() {
+		done <- true
+	})))
+
+	<-done
+	return nil
 }
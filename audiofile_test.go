@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadConfig reads a config written to a temporary directory, as osc-map would at start up
+func loadConfig(t *testing.T, config string) *OSCMap {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := &OSCMap{history: newCueHistory(DefaultCueHistory), lights: newLightStates(), configFile: file}
+	if _, err := m.readConfig(file); err != nil {
+		t.Fatalf("cannot load config: %v", err)
+	}
+	return m
+}
+
+func TestAudioFilesOff(t *testing.T) {
+	m := loadConfig(t, `
+outputs:
+  audio-files: false
+control-cue-mapping:
+  - in: "1"
+    file: "intro.wav"
+`)
+
+	record := m.fireCue("1", "test")
+	<-record.done
+	if outputs := m.history.snapshot(record).Outputs; len(outputs) != 0 {
+		t.Errorf("cue ran %v with audio-files off", outputs)
+	}
+
+	if err := m.playAudioFile("1", "1"); err == nil {
+		t.Error("audio played with audio-files off")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	minKelvin = 1000
+	maxKelvin = 12000
+)
+
+// colorSpec is a color as written in the config, either a list of RGBW values or a string such as a hex code,
+// color name, hsv(), Kelvin temperature or gel number. It is only checked by rgbw, so that a bad color in an
+// edited config is reported rather than stopping the program.
+type colorSpec struct {
+	values []int
+	text   string
+}
+
+func (c *colorSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&c.values)
+	}
+	return value.Decode(&c.text)
+}
+
+// rgbwSpec writes an RGBW color as a colorSpec, for colors compiled into osc-map
+func rgbwSpec(rgbw ...int) colorSpec {
+	return colorSpec{values: rgbw}
+}
+
+func (c colorSpec) String() string {
+	if c.text != "" {
+		return strconv.Quote(c.text)
+	}
+	return fmt.Sprint(c.values)
+}
+
+// rgbw validates the color and converts it to RGBW
+func (c colorSpec) rgbw() ([]int, error) {
+	if c.text == "" {
+		if len(c.values) != 4 {
+			return nil, fmt.Errorf("RGBW needs 4 values, got %v", c.values)
+		}
+		for _, v := range c.values {
+			if v < 0 || v > 255 {
+				return nil, fmt.Errorf("RGBW values must be from 0-255, got %v", c.values)
+			}
+		}
+		return c.values, nil
+	}
+
+	return parseColor(c.text)
+}
+
+// parseColor reads a color string, trying each of the formats in turn
+func parseColor(text string) ([]int, error) {
+	s := strings.ToLower(strings.TrimSpace(text))
+
+	if strings.HasPrefix(s, "#") {
+		return parseHexColor(s[1:])
+	}
+
+	if strings.HasPrefix(s, "hsv(") && strings.HasSuffix(s, ")") {
+		return parseHSVColor(s[4 : len(s)-1])
+	}
+
+	if strings.HasSuffix(s, "k") {
+		kelvin, err := strconv.Atoi(strings.TrimSpace(s[:len(s)-1]))
+		if err == nil {
+			if kelvin < minKelvin || kelvin > maxKelvin {
+				return nil, fmt.Errorf("color temperature %s must be from %dK-%dK", text, minKelvin, maxKelvin)
+			}
+			return rgbToRGBW(kelvinToRGB(kelvin)), nil
+		}
+	}
+
+	name := strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '-' || r == '_' }), " ")
+	if rgbw, ok := namedColors[name]; ok {
+		return rgbw, nil
+	}
+	if rgb, ok := gelColors[gelKey(name)]; ok {
+		return rgbToRGBW(rgb), nil
+	}
+
+	return nil, fmt.Errorf("unknown color %q", text)
+}
+
+// parseHexColor reads RRGGBB, which has the white taken out of it, or RRGGBBWW
+func parseHexColor(hex string) ([]int, error) {
+	if len(hex) != 6 && len(hex) != 8 {
+		return nil, fmt.Errorf("hex color #%s needs 6 or 8 digits", hex)
+	}
+
+	values := make([]int, len(hex)/2)
+	for i := range values {
+		v, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex color #%s", hex)
+		}
+		values[i] = int(v)
+	}
+
+	if len(values) == 4 {
+		return values, nil
+	}
+	return rgbToRGBW(values), nil
+}
+
+// parseHSVColor reads "hue, saturation, value" with the hue in degrees and the others from 0-100, with an optional %
+func parseHSVColor(args string) ([]int, error) {
+	parts := strings.Split(args, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("hsv(%s) needs a hue, saturation and value", args)
+	}
+
+	var hsv [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(part), "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hsv(%s): %v", args, err)
+		}
+		hsv[i] = v
+	}
+	if hsv[0] < 0 || hsv[0] > 360 || hsv[1] < 0 || hsv[1] > 100 || hsv[2] < 0 || hsv[2] > 100 {
+		return nil, fmt.Errorf("hsv(%s) needs a hue from 0-360 and a saturation and value from 0-100", args)
+	}
+
+	return rgbToRGBW(hsvToRGB(hsv[0], hsv[1]/100, hsv[2]/100)), nil
+}
+
+func hsvToRGB(h float64, s float64, v float64) []int {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return []int{
+		int(math.Round((r + m) * 255)),
+		int(math.Round((g + m) * 255)),
+		int(math.Round((b + m) * 255)),
+	}
+}
+
+// rgbToRGBW moves the part of a color shared by red, green and blue onto the white channel
+func rgbToRGBW(rgb []int) []int {
+	w := rgb[0]
+	for _, c := range rgb[1:] {
+		if c < w {
+			w = c
+		}
+	}
+	return []int{rgb[0] - w, rgb[1] - w, rgb[2] - w, w}
+}
+
+// kelvinToRGB approximates the color of a black body, after Tanner Helland
+func kelvinToRGB(kelvin int) []int {
+	t := float64(kelvin) / 100
+
+	var r, g, b float64
+	if t <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+	switch {
+	case t >= 66:
+		b = 255
+	case t <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+
+	return []int{
+		clampColor(int(math.Round(r))),
+		clampColor(int(math.Round(g))),
+		clampColor(int(math.Round(b))),
+	}
+}
+
+// foldWhite adds the white channel into red, green and blue for fixtures without one
+func foldWhite(rgbw []int) []int {
+	return []int{clampColor(rgbw[0] + rgbw[3]), clampColor(rgbw[1] + rgbw[3]), clampColor(rgbw[2] + rgbw[3])}
+}
+
+// colorBrightness is the brightest channel of a color once the white is added to red, green and blue
+func colorBrightness(rgbw []int) int {
+	return maxChannel(foldWhite(rgbw))
+}
+
+// withBrightness keeps the hue of the current color at the brightness of the target color. A light that is off takes the target color.
+func withBrightness(current []int, target []int) []int {
+	brightness := colorBrightness(current)
+	if brightness == 0 {
+		return target
+	}
+	return scaleColor(current, float64(colorBrightness(target))/float64(brightness))
+}
+
+// rgbwToKelvin finds the color temperature between low and high closest to the color. White alone is taken as neutral.
+func rgbwToKelvin(rgbw []int, low int, high int) int {
+	if rgbw[0]+rgbw[1]+rgbw[2] == 0 {
+		return (low + high) / 2
+	}
+
+	rgb := foldWhite(rgbw)
+	best, bestDistance := low, math.Inf(1)
+	for kelvin := low; kelvin <= high; kelvin += 50 {
+		distance := colorDistance(rgb, kelvinToRGB(kelvin))
+		if distance < bestDistance {
+			best, bestDistance = kelvin, distance
+		}
+	}
+	return best
+}
+
+// colorDistance compares the hue of two colors, ignoring their brightness
+func colorDistance(a []int, b []int) float64 {
+	maxA, maxB := float64(maxChannel(a)), float64(maxChannel(b))
+	if maxA == 0 || maxB == 0 {
+		return math.Inf(1)
+	}
+
+	var distance float64
+	for i := range a {
+		d := float64(a[i])/maxA - float64(b[i])/maxB
+		distance += d * d
+	}
+	return distance
+}
+
+// namedColors may be used anywhere a color is, with spaces, hyphens or underscores between words
+var namedColors = map[string][]int{
+	"off":        {0, 0, 0, 0},
+	"black":      {0, 0, 0, 0},
+	"white":      {0, 0, 0, 255},
+	"full":       {255, 255, 255, 255},
+	"warm white": rgbToRGBW(kelvinToRGB(2700)),
+	"cool white": rgbToRGBW(kelvinToRGB(6500)),
+	"red":        {255, 0, 0, 0},
+	"orange":     {255, 128, 0, 0},
+	"amber":      {255, 191, 0, 0},
+	"yellow":     {255, 255, 0, 0},
+	"lime":       {128, 255, 0, 0},
+	"green":      {0, 255, 0, 0},
+	"teal":       {0, 255, 128, 0},
+	"cyan":       {0, 255, 255, 0},
+	"sky blue":   {0, 128, 255, 0},
+	"blue":       {0, 0, 255, 0},
+	"purple":     {128, 0, 255, 0},
+	"magenta":    {255, 0, 255, 0},
+	"pink":       {255, 0, 128, 0},
+}
+
+// gelKey normalises a gel number such as "R80", "r 80", "Rosco 80" or "Lee 201" to "r80" or "l201"
+func gelKey(name string) string {
+	s := strings.ReplaceAll(name, " ", "")
+	s = strings.Replace(s, "rosco", "r", 1)
+	s = strings.Replace(s, "lee", "l", 1)
+	return s
+}
+
+// gelColors approximates the color of some common Rosco (R) and Lee (L) gels over a tungsten source
+var gelColors = map[string][]int{
+	"r02":  {255, 190, 130},
+	"r08":  {255, 210, 150},
+	"r09":  {255, 180, 120},
+	"r21":  {255, 140, 40},
+	"r26":  {225, 20, 50},
+	"r27":  {190, 0, 30},
+	"r33":  {255, 195, 185},
+	"r44":  {255, 120, 160},
+	"r51":  {255, 205, 200},
+	"r54":  {230, 200, 240},
+	"r60":  {200, 220, 255},
+	"r64":  {140, 190, 255},
+	"r68":  {60, 140, 255},
+	"r80":  {0, 70, 255},
+	"r89":  {40, 180, 60},
+	"r91":  {0, 160, 40},
+	"l106": {230, 10, 30},
+	"l119": {0, 40, 200},
+	"l132": {0, 110, 230},
+	"l139": {20, 160, 40},
+	"l147": {255, 160, 100},
+	"l164": {255, 60, 20},
+	"l181": {50, 0, 140},
+	"l201": {200, 220, 255},
+	"l202": {225, 235, 255},
+	"l204": {255, 190, 130},
+	"l205": {255, 215, 170},
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v3"
 )
 
+const DefaultConfigFile = "config.yaml"
+
 // monitorConfig watches for changes in the config and will update the midiMap in real time so the program doesn't need to be restarted when a new cue is added to the config
 func (m *OSCMap) monitorConfig() {
 	watcher, err := fsnotify.NewWatcher()
@@ -16,6 +21,8 @@ func (m *OSCMap) monitorConfig() {
 	}
 	defer watcher.Close()
 
+	watching := m.show()
+
 	done := make(chan bool)
 	go func() {
 		defer close(done)
@@ -26,12 +33,23 @@ func (m *OSCMap) monitorConfig() {
 				if !ok {
 					return
 				}
-				_, err := m.readConfig()
+				err := m.reloadConfig()
 				if err != nil {
 					log.Errorf("Failed to read config: %v", err)
 				}
 
 				log.Infof("Config file changed: %s %s", event.Name, event.Op)
+			case <-m.showChanged:
+				// watch the new show's config instead
+				show := m.show()
+				if show == watching {
+					continue
+				}
+				watcher.Remove(watching)
+				if err := watcher.Add(show); err != nil {
+					log.Errorf("Failed to watch %s: %v", show, err)
+				}
+				watching = show
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -42,36 +60,170 @@ func (m *OSCMap) monitorConfig() {
 
 	}()
 
-	err = watcher.Add("config.yaml")
+	err = watcher.Add(watching)
 	if err != nil {
 		log.Fatal("Add failed:", err)
 	}
 	<-done
 }
 
-func (m *OSCMap) readConfig() (*conf, error) {
-	confBytes, err := os.ReadFile("config.yaml")
+// showSettings is everything a reload replaces. It is swapped as a whole, so a cue that is running while the
+// config is reloaded sees the cues, fixtures and effects of one config rather than a mix of two.
+type showSettings struct {
+	controlMap    map[string]cueMap
+	fixtures      *fixturePatch
+	customEffects map[string]*customEffect
+	allStop       confAllStop
+	audio         audioSettings
+}
+
+// settings returns the show settings that are loaded. They must not be changed, as other cues share them.
+func (m *OSCMap) settings() *showSettings {
+	return m.loaded.Load()
+}
+
+// show returns the config file that is loaded
+func (m *OSCMap) show() string {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+
+	return m.configFile
+}
+
+// reloadConfig reads the loaded config file again, keeping the current cues if it is invalid
+func (m *OSCMap) reloadConfig() error {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+
+	_, err := m.readConfig(m.configFile)
+	events.publish(Event{Type: "reload", Show: m.configFile, Error: errorText(err)})
+	return err
+}
+
+// switchShow loads another config file in place of the current one, which is kept if the new one is invalid.
+// Outputs such as MIDI and the web panel are only set up at start up, so they are not changed.
+func (m *OSCMap) switchShow(file string) error {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+
+	if _, err := m.readConfig(file); err != nil {
+		events.publish(Event{Type: "reload", Show: file, Error: err.Error()})
+		return err
+	}
+	log.Infof("Switched show to %s", file)
+	m.configFile = file
+	events.publish(Event{Type: "reload", Show: file})
+
+	select {
+	case m.showChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (m *OSCMap) readConfig(file string) (*conf, error) {
+	confBytes, err := os.ReadFile(file)
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
 	conf := &conf{}
 	err = yaml.Unmarshal(confBytes, conf)
 	if err != nil {
-		log.Fatalf("Failed to unmarshal config file: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal config file: %v", err)
 	}
 
 	// print config and exit
 	log.Debugf("Config: %+v", conf)
 
+	level, err := logLevel(conf.Log)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log settings: %v", err)
+	}
+
+	if conf.AllStop.Hotkey != "" {
+		if _, err := parseHotkey(conf.AllStop.Hotkey); err != nil {
+			return nil, fmt.Errorf("invalid all stop hotkey: %v", err)
+		}
+	}
+
+	if conf.Outputs.KeyboardWindow != "" {
+		if err := activeWindowSupported(); err != nil {
+			return nil, err
+		}
+	}
+
+	fixtures, err := newFixturePatch(conf.Fixtures, conf.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixtures: %v", err)
+	}
+
+	// the DMX output keeps the protocol it was started with until osc-map is restarted
+	protocol := dmxProtocol(strings.ToLower(conf.Outputs.DMX.Protocol))
+	if m.dmx != nil {
+		protocol = m.dmx.protocol
+	}
+	if err := fixtures.checkUniverses(protocol); err != nil {
+		return nil, fmt.Errorf("invalid fixtures: %v", err)
+	}
+
+	looks, err := fixtures.buildLooks(conf.Looks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid looks: %v", err)
+	}
+
+	customEffects, err := buildCustomEffects(conf.CustomEffects)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom effects: %v", err)
+	}
+
 	// create midi map
 	controlMap := make(map[string]cueMap)
 	for _, cm := range conf.ControlCueMapping {
 
-		// parse hex from config to int
-		keyboard, ok := KeyboardMap[cm.Keyboard]
-		if !ok {
-			keyboard = -1
+		// expand the look and houselights into one command per light, the houselights taking precedence
+		var lookLights []lightCommand
+		if cm.Look != "" {
+			look, ok := looks[strings.ToLower(cm.Look)]
+			if !ok {
+				return nil, fmt.Errorf("unknown look %q in cue[%v]", cm.Look, cm.In)
+			}
+			lookLights = look
+		}
+		cueLights, err := fixtures.lightCommands(cm.HouseLights, cm.RGBWs, cm.Transitions, cm.Effects, cm.Modes, cm.Holds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid houselights in cue[%v]: %v", cm.In, err)
+		}
+		for _, command := range cueLights {
+			if _, ok := customEffects[command.effect]; ok && command.mode == TransitionRelease {
+				return nil, fmt.Errorf("invalid houselights in cue[%v]: custom effect %q cannot be released", cm.In, command.effect)
+			}
+		}
+
+		mqttActions, err := buildMQTTActions(cm.MQTT)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mqtt in cue[%v]: %v", cm.In, err)
+		}
+
+		execActions, err := buildExecActions(cm.Exec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec in cue[%v]: %v", cm.In, err)
+		}
+
+		httpActions, err := buildHTTPActions(cm.HTTP)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http in cue[%v]: %v", cm.In, err)
+		}
+
+		services, err := buildHAServiceActions(cm.Services)
+		if err != nil {
+			return nil, fmt.Errorf("invalid services in cue[%v]: %v", cm.In, err)
+		}
+
+		// expand the keyboard action into the keys to press
+		keyboard, err := cm.Keyboard.steps()
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyboard in cue[%v]: %v", cm.In, err)
 		}
 
 		newCM := cueMap{
@@ -80,17 +232,68 @@ func (m *OSCMap) readConfig() (*conf, error) {
 			unmuteCue:   cm.Unmute,
 			faderCue:    cm.FaderChannel,
 			faderVal:    cm.FaderValue,
-			keyboardKey: keyboard,
+			keyboard:    keyboard,
 			audioFile:   cm.AudioFile,
-			houseLights: cm.HouseLights,
-			rgbws:       cm.RGBWs,
-			transitions: cm.Transitions,
-			effects:     cm.Effects,
+			audioLevel:  cm.AudioLevel,
+			audioBus:    cm.AudioBus,
+			houseLights: mergeLightCommands(lookLights, cueLights),
+			restore:     cm.Restore,
+			mqtt:        mqttActions,
+			exec:        execActions,
+			http:        httpActions,
+			services:    services,
 		}
 		controlMap[cm.In] = newCM
 	}
 
-	m.controlMap = controlMap
+	for cue, cm := range controlMap {
+		if cm.restore == "" {
+			continue
+		}
+		if restoreCM, ok := controlMap[cm.restore]; !ok || (len(restoreCM.houseLights) == 0 && restoreCM.restore == "") {
+			return nil, fmt.Errorf("cue[%v] restores cue[%v], which has no houselights", cue, cm.restore)
+		}
+	}
+
+	// the settings are built even with audio-files off, so that nothing reading them finds them empty
+	audio, err := newAudioSettings(conf.Outputs.Audio, filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("invalid audio settings: %v", err)
+	}
+	if conf.Outputs.AudioFiles {
+		if m.speakerSampleRate != 0 && audio.sampleRate != m.speakerSampleRate {
+			log.Warnf("Sample rate changed to %d Hz, restart to apply. Playing at %d Hz until then", audio.sampleRate, m.speakerSampleRate)
+			audio.sampleRate = m.speakerSampleRate
+		}
+		// copies are only kept while the cache stays on, turning it off plays the originals again
+		if loaded := m.settings(); audio.cacheEnabled && loaded != nil && loaded.audio.cacheEnabled {
+			audio.cache = loaded.audio.cache
+		}
+	}
+
+	log.SetLevel(level)
+	m.loaded.Store(&showSettings{
+		controlMap:    controlMap,
+		fixtures:      fixtures,
+		customEffects: customEffects,
+		allStop:       conf.AllStop,
+		audio:         audio,
+	})
+
+	// checking the audio files can take a while, so it is not held against the config lock or the cues
+	if conf.Outputs.AudioFiles {
+		go audio.checkFiles(controlMap)
+	}
+
+	// a fixture on a universe that is new to this config follows the lightboard there too
+	if m.dmx != nil {
+		m.dmx.joinUniverses()
+	}
+
+	// acknowledgements for new cues are subscribed to straight away, the client keeps them across reconnects
+	if m.mqtt != nil {
+		go m.mqtt.subscribe(mqttAckTopics(controlMap))
+	}
 
 	return conf, nil
 }
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+const (
+	consolePrompt  = "osc-map> "
+	consoleHistory = 100 // commands kept for the up and down arrows
+)
+
+var consoleCommands = []string{"go", "list", "show", "stop", "lights", "midi", "reload", "help", "quit"}
+
+const consoleHelp = `Commands:
+  go <cue>     fire a cue, just as the lightboard would
+  list         list every mapped cue and the outputs it uses
+  show <cue>   show everything a cue does
+  stop         all stop, as does typing the all stop hotkey
+  lights       show the state of every house light
+  midi ports   list the midi output ports
+  reload       read the config file again
+  quit         exit osc-map
+Tab completes commands and cue numbers.`
+
+// console is an interactive prompt for firing cues by hand when the lightboard is not available. It runs
+// alongside the OSC listener, and log lines are printed above the prompt without disturbing what is being typed.
+type console struct {
+	m   *OSCMap
+	out io.Writer
+
+	mutex   sync.Mutex
+	line    []rune
+	raw     bool
+	history []string
+}
+
+func newConsole(m *OSCMap, out io.Writer) *console {
+	return &console{m: m, out: out}
+}
+
+// Write prints log output above the prompt, so that it can be used as the logger's output
+func (c *console) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.raw {
+		return c.out.Write(p)
+	}
+	fmt.Fprint(c.out, "\r\x1b[K")
+	n, err := c.out.Write(p)
+	c.redraw()
+	return n, err
+}
+
+// printf prints a line of command output above the prompt
+func (c *console) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c, format+"\n", args...)
+}
+
+// redraw writes the prompt and the line being typed, which must be called with the mutex held
+func (c *console) redraw() {
+	fmt.Fprintf(c.out, "\r\x1b[K%s%s", consolePrompt, string(c.line))
+}
+
+// run reads commands until quit, ctrl+c or the end of the input. On a terminal the line is edited in raw
+// mode for tab completion, otherwise commands are read a line at a time.
+func (c *console) run(in *os.File) {
+	if !isTerminal(in.Fd()) {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			if !c.execute(scanner.Text()) {
+				return
+			}
+		}
+		return
+	}
+
+	restore, err := makeRaw(in.Fd())
+	if err != nil {
+		log.Errorf("Failed to set up the console: %v", err)
+		return
+	}
+	defer restore()
+
+	c.mutex.Lock()
+	c.raw = true
+	c.redraw()
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		c.raw = false
+		fmt.Fprint(c.out, "\r\n")
+		c.mutex.Unlock()
+	}()
+
+	reader := bufio.NewReader(in)
+	recall := 0 // how far back in the history the up arrow has gone
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			return
+		}
+
+		switch r {
+		case '\r', '\n':
+			c.mutex.Lock()
+			command := string(c.line)
+			c.line = nil
+			fmt.Fprint(c.out, "\r\n")
+			if strings.TrimSpace(command) != "" && (len(c.history) == 0 || c.history[len(c.history)-1] != command) {
+				c.history = append(c.history, command)
+				if len(c.history) > consoleHistory {
+					c.history = c.history[1:]
+				}
+			}
+			c.mutex.Unlock()
+			recall = 0
+
+			if !c.execute(command) {
+				return
+			}
+			c.mutex.Lock()
+			c.redraw()
+			c.mutex.Unlock()
+		case 3: // ctrl+c
+			return
+		case 4: // ctrl+d, only on an empty line
+			c.mutex.Lock()
+			empty := len(c.line) == 0
+			c.mutex.Unlock()
+			if empty {
+				return
+			}
+		case 127, 8: // backspace
+			c.edit(func() {
+				if len(c.line) != 0 {
+					c.line = c.line[:len(c.line)-1]
+				}
+			})
+		case 21: // ctrl+u
+			c.edit(func() {
+				c.line = nil
+			})
+		case '\t':
+			c.complete()
+		case 27: // escape sequences, only the up and down arrows are used
+			if next, _, _ := reader.ReadRune(); next != '[' && next != 'O' {
+				continue
+			}
+			code, _, _ := reader.ReadRune()
+			for code >= '0' && code <= '9' || code == ';' {
+				code, _, _ = reader.ReadRune()
+			}
+			switch code {
+			case 'A':
+				recall++
+			case 'B':
+				recall--
+			default:
+				continue
+			}
+			c.edit(func() {
+				if recall > len(c.history) {
+					recall = len(c.history)
+				}
+				if recall <= 0 {
+					recall = 0
+					c.line = nil
+					return
+				}
+				c.line = []rune(c.history[len(c.history)-recall])
+			})
+		default:
+			if r >= ' ' {
+				c.edit(func() {
+					c.line = append(c.line, r)
+				})
+			}
+		}
+	}
+}
+
+// edit changes the line being typed and redraws it
+func (c *console) edit(change func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	change()
+	c.redraw()
+}
+
+// complete extends the line as far as every candidate agrees, and lists the candidates if it cannot go any further
+func (c *console) complete() {
+	c.mutex.Lock()
+	line := string(c.line)
+	c.mutex.Unlock()
+
+	candidates := c.m.consoleCompletions(line)
+	if len(candidates) == 0 {
+		return
+	}
+
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+
+	if len(prefix) > len(line) {
+		c.edit(func() {
+			c.line = []rune(prefix)
+		})
+		return
+	}
+
+	// show just the word being completed for each candidate
+	start := strings.LastIndex(line, " ") + 1
+	words := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		words[i] = candidate[start:]
+	}
+	c.printf("%s", strings.Join(words, "  "))
+}
+
+// consoleCompletions returns every full line that the line being typed could complete to
+func (m *OSCMap) consoleCompletions(line string) []string {
+	fields := strings.Fields(line)
+	typing := len(fields) == 0 || !strings.HasSuffix(line, " ")
+	if typing && len(fields) != 0 {
+		fields = fields[:len(fields)-1]
+	}
+	word := ""
+	if typing && strings.TrimSpace(line) != "" {
+		word = line[strings.LastIndex(line, " ")+1:]
+	}
+
+	var options []string
+	switch {
+	case len(fields) == 0:
+		options = consoleCommands
+	case len(fields) == 1 && (fields[0] == "go" || fields[0] == "show"):
+		options = cueOrder(m.settings().controlMap)
+	case len(fields) == 1 && fields[0] == "midi":
+		options = []string{"ports"}
+	}
+
+	base := line[:len(line)-len(word)]
+	var candidates []string
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			candidates = append(candidates, base+option)
+		}
+	}
+	return candidates
+}
+
+// execute runs one command, and returns false to quit
+func (c *console) execute(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return true
+	}
+
+	// the all stop hotkey is typed in the same way as outside of console mode
+	if hotkey := c.m.settings().allStop.Hotkey; hotkey != "" && strings.EqualFold(strings.TrimSpace(command), hotkey) {
+		c.m.AllStop()
+		return true
+	}
+
+	switch fields[0] {
+	case "go":
+		if len(fields) != 2 {
+			c.printf("Usage: go <cue>")
+			break
+		}
+		record := c.m.fireCue(fields[1], "console")
+		go func() {
+			<-record.done
+			c.printf("%s", c.m.describeResult(c.m.history.snapshot(record)))
+		}()
+	case "list":
+		for _, cue := range c.m.Cues() {
+			c.printf("%-8s %s", cue.Cue, strings.Join(cue.Outputs, ", "))
+		}
+	case "show":
+		if len(fields) != 2 {
+			c.printf("Usage: show <cue>")
+			break
+		}
+		mc, ok := c.m.settings().controlMap[fields[1]]
+		if !ok {
+			c.printf("Cue %s is not mapped", fields[1])
+			break
+		}
+		for _, line := range c.m.describeCue(mc) {
+			c.printf("%s", line)
+		}
+	case "stop":
+		c.m.AllStop()
+	case "lights":
+		for _, l := range c.m.HouseLightStatus() {
+			name := l.Name
+			if name == "" {
+				name = l.Entity
+			}
+			c.printf("%3d %-20s %-10s rgbw %v %s %s", l.Light, name, l.Owner, l.RGBW, l.Effect, l.Cue)
+		}
+	case "midi":
+		if len(fields) != 2 || fields[1] != "ports" {
+			c.printf("Usage: midi ports")
+			break
+		}
+		for _, port := range midi.GetOutPorts() {
+			using := ""
+			if (c.m.midiOut != nil && port.String() == (*c.m.midiOut).String()) || (c.m.qlabOut != nil && port.String() == (*c.m.qlabOut).String()) {
+				using = " (in use)"
+			}
+			c.printf("%s%s", port.String(), using)
+		}
+	case "reload":
+		if err := c.m.reloadConfig(); err != nil {
+			c.printf("Failed to reload %s: %v", c.m.show(), err)
+			break
+		}
+		c.printf("Reloaded %s", c.m.show())
+	case "help", "?":
+		c.printf("%s", consoleHelp)
+	case "quit", "exit":
+		return false
+	default:
+		c.printf("Unknown command %q, type help for the list of commands", fields[0])
+	}
+	return true
+}
+
+// describeResult summarizes what each output did for a cue
+func (m *OSCMap) describeResult(r CueRecord) string {
+	if !r.Mapped {
+		return fmt.Sprintf("Cue %s is not mapped", r.Cue)
+	}
+	if len(r.Outputs) == 0 {
+		return fmt.Sprintf("Cue %s has nothing to do", r.Cue)
+	}
+	results := make([]string, len(r.Outputs))
+	for i, o := range r.Outputs {
+		results[i] = o.Output + " " + o.Status
+		if o.Error != "" {
+			results[i] += " (" + o.Error + ")"
+		}
+	}
+	return fmt.Sprintf("Cue %s: %s", r.Cue, strings.Join(results, ", "))
+}
+
+// describeCue lists everything a cue does, one line for each action
+func (m *OSCMap) describeCue(mc cueMap) []string {
+	var lines []string
+	for _, output := range []string{"midi", "keyboard", "audio", "mqtt", "exec", "http", "services", "houselights"} {
+		for _, action := range describeOutput(mc, output) {
+			lines = append(lines, output+": "+action)
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "nothing to do")
+	}
+	return lines
+}
+
+// describeOutput lists what one of a cue's outputs does
+func describeOutput(mc cueMap, output string) []string {
+	var actions []string
+	switch output {
+	case "midi":
+		if mc.soundCue != 0 {
+			actions = append(actions, fmt.Sprintf("program change %d", mc.soundCue))
+		}
+		if len(mc.muteCue) != 0 {
+			actions = append(actions, fmt.Sprintf("mute %v", mc.muteCue))
+		}
+		if len(mc.unmuteCue) != 0 {
+			actions = append(actions, fmt.Sprintf("unmute %v", mc.unmuteCue))
+		}
+		if len(mc.faderCue) != 0 {
+			actions = append(actions, fmt.Sprintf("faders %v to %v", mc.faderCue, mc.faderVal))
+		}
+	case "keyboard":
+		if len(mc.keyboard) != 0 {
+			var keys []string
+			for _, step := range mc.keyboard {
+				if step.wait > 0 {
+					keys = append(keys, "wait "+step.wait.String())
+				} else {
+					keys = append(keys, step.key.name)
+				}
+			}
+			actions = append(actions, strings.Join(keys, ", "))
+		}
+	case "audio":
+		if mc.audioFile != "" {
+			bus := mc.audioBus
+			if bus == "" {
+				bus = DefaultAudioBus
+			}
+			actions = append(actions, fmt.Sprintf("%s at %v dB on %s", mc.audioFile, mc.audioLevel, bus))
+		}
+	case "houselights":
+		houseLights := append([]lightCommand(nil), mc.houseLights...)
+		sort.Slice(houseLights, func(i, j int) bool {
+			return houseLights[i].light < houseLights[j].light
+		})
+		for _, l := range houseLights {
+			action := fmt.Sprintf("%d rgbw %v over %vs", l.light, l.rgbw, l.transition)
+			if l.effect != "" {
+				action += " " + l.effect
+			}
+			actions = append(actions, action)
+		}
+		if mc.restore != "" {
+			actions = append(actions, "restore cue "+mc.restore)
+		}
+	case "mqtt":
+		for _, a := range mc.mqtt {
+			actions = append(actions, a.topic)
+		}
+	case "exec":
+		for _, a := range mc.exec {
+			actions = append(actions, strings.Join(append([]string{a.command}, a.args...), " "))
+		}
+	case "http":
+		for _, a := range mc.http {
+			actions = append(actions, fmt.Sprintf("%s %s", a.method, a.url.Root.String()))
+		}
+	case "services":
+		for _, a := range mc.services {
+			actions = append(actions, fmt.Sprintf("%s.%s %v", a.domain, a.service, a.entities))
+		}
+	}
+	return actions
+}
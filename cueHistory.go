@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultCueHistory = 50 // cues kept for the web panel
+
+// cueError logs an output's failure and returns it, so that it is also shown against the cue in the history
+func cueError(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	log.Error(err)
+	return err
+}
+
+// OutputResult is what one output did for a fired cue
+type OutputResult struct {
+	Output   string        `json:"output"`
+	Action   string        `json:"action"`
+	Status   string        `json:"status"` // running, ok or error
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// CueRecord is a cue that has been fired, with the result of every output it uses
+type CueRecord struct {
+	Cue      string         `json:"cue"`
+	Resolved string         `json:"resolved,omitempty"` // the mapped cue that was run, which may be the cue without its .0
+	Source   string         `json:"source"`
+	Args     []interface{}  `json:"args,omitempty"` // the OSC arguments the cue was received with
+	Time     time.Time      `json:"time"`
+	Mapped   bool           `json:"mapped"`
+	Outputs  []OutputResult `json:"outputs"`
+
+	done chan struct{} // closed once every output has finished
+}
+
+// cueHistory keeps the most recently fired cues, newest last
+type cueHistory struct {
+	mutex   sync.Mutex
+	size    int
+	records []*CueRecord
+}
+
+func newCueHistory(size int) *cueHistory {
+	return &cueHistory{size: size}
+}
+
+func (h *cueHistory) add(r *CueRecord) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.records = append(h.records, r)
+	if len(h.records) > h.size {
+		h.records = h.records[len(h.records)-h.size:]
+	}
+}
+
+// finish records the result of an output that was started as running
+func (h *cueHistory) finish(r *CueRecord, output int, started time.Time, err error) OutputResult {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	result := &r.Outputs[output]
+	result.Duration = time.Since(started)
+	result.Status = "ok"
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+	}
+	return *result
+}
+
+// snapshot returns a copy of a record that is safe to read while its outputs run
+func (h *cueHistory) snapshot(r *CueRecord) CueRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	copied := *r
+	copied.Outputs = append([]OutputResult{}, r.Outputs...)
+	return copied
+}
+
+// recent returns a copy of the history, newest first
+func (h *cueHistory) recent() []CueRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	records := make([]CueRecord, 0, len(h.records))
+	for i := len(h.records) - 1; i >= 0; i-- {
+		copied := *h.records[i]
+		copied.Outputs = append([]OutputResult{}, copied.Outputs...)
+		records = append(records, copied)
+	}
+	return records
+}
+
+// extractDecimal extracts the decimal part from a string in the format "decimal_label"
+func extractDecimal(input string) string {
+	// Find the position of the underscore
+	underscoreIndex := strings.Index(input, "_")
+	if underscoreIndex == -1 {
+		// If no underscore is found, return the entire string (assuming it's just the decimal)
+		return input
+	}
+	// Return the substring before the underscore
+	return input[:underscoreIndex]
+}
+
+// cueOutput is an output that a cue uses
+type cueOutput struct {
+	name string
+	send func(cueNumber string, cueInteger string) error
+}
+
+// cueOutputs lists the outputs a cue uses, in the order they are recorded. The house lights are always last.
+func (m *OSCMap) cueOutputs(mc cueMap) []cueOutput {
+	var outputs []cueOutput
+	if m.midiOut != nil && (mc.soundCue != 0 || len(mc.muteCue) != 0 || len(mc.unmuteCue) != 0 || len(mc.faderCue) != 0) {
+		outputs = append(outputs, cueOutput{"midi", m.sendMidiOut})
+	}
+	if m.keyboard != nil && len(mc.keyboard) != 0 {
+		outputs = append(outputs, cueOutput{"keyboard", m.sendKeyboardCommand})
+	}
+	if m.speakerSampleRate != 0 && mc.audioFile != "" {
+		outputs = append(outputs, cueOutput{"audio", m.playAudioFile})
+	}
+	if len(mc.mqtt) != 0 {
+		outputs = append(outputs, cueOutput{"mqtt", m.sendMQTT})
+	}
+	if len(mc.exec) != 0 {
+		outputs = append(outputs, cueOutput{"exec", m.runExec})
+	}
+	if len(mc.http) != 0 {
+		outputs = append(outputs, cueOutput{"http", m.callWebhooks})
+	}
+	if len(mc.services) != 0 {
+		outputs = append(outputs, cueOutput{"services", m.callHAServices})
+	}
+	if len(mc.houseLights) != 0 || mc.restore != "" {
+		outputs = append(outputs, cueOutput{"houselights", m.toggleLight})
+	}
+	return outputs
+}
+
+// fireCue runs every output for a cue and records the results. It is used for cues from the lightboard
+// and for cues fired by hand, so both behave the same. The record's outputs keep running after it returns.
+// Any OSC arguments the cue arrived with are kept in the record.
+func (m *OSCMap) fireCue(cue string, source string, args ...interface{}) *CueRecord {
+	// Trim the cue label and underscore
+	cueNumber := extractDecimal(cue)
+
+	// If cue number ends in 0, make an optional second to test
+	cueInteger := strings.Clone(cueNumber)
+	if strings.Contains(cueInteger, ".0") {
+		cueInteger = strings.ReplaceAll(cueInteger, ".0", "")
+	}
+	log.Infof("Received cue number: %v from %s", cueNumber, source)
+
+	record := &CueRecord{Cue: cueNumber, Source: source, Args: args, Time: time.Now(), Outputs: []OutputResult{}, done: make(chan struct{})}
+	record.Resolved = cueNumber
+	controlMap := m.settings().controlMap
+	mc, ok := controlMap[cueNumber]
+	if !ok {
+		record.Resolved = cueInteger
+		mc, ok = controlMap[cueInteger]
+	}
+	if !ok {
+		record.Resolved = ""
+	}
+	record.Mapped = ok
+
+	// only the outputs the cue uses are recorded and run
+	outputs := m.cueOutputs(mc)
+	for _, o := range outputs {
+		action := strings.Join(describeOutput(mc, o.name), "; ")
+		record.Outputs = append(record.Outputs, OutputResult{Output: o.name, Action: action, Status: "running"})
+	}
+	m.history.add(record)
+	events.publish(Event{Type: "cue", Time: record.Time, Cue: cueNumber, Source: source})
+
+	var wg sync.WaitGroup
+	run := func(i int, o cueOutput) {
+		defer wg.Done()
+		started := time.Now()
+		result := m.history.finish(record, i, started, o.send(cueNumber, cueInteger))
+		events.publish(Event{Type: "output", Cue: cueNumber, Source: source, Output: &result})
+	}
+
+	wg.Add(len(outputs))
+	for i, o := range outputs {
+		if o.name != "houselights" {
+			go run(i, o)
+		}
+	}
+
+	// house light commands are only queued, so they are handled in order of arrival
+	if last := len(outputs) - 1; last >= 0 && outputs[last].name == "houselights" {
+		run(last, outputs[last])
+	}
+
+	go func() {
+		wg.Wait()
+		close(record.done)
+		if m.eventLog != nil {
+			m.eventLog.write(m.history.snapshot(record))
+		}
+	}()
+	return record
+}
+
+// cueOrder returns the mapped cue numbers in the order they run in
+func cueOrder(controlMap map[string]cueMap) []string {
+	cues := make([]string, 0, len(controlMap))
+	for cue := range controlMap {
+		cues = append(cues, cue)
+	}
+	sort.Slice(cues, func(i, j int) bool {
+		return cueLess(cues[i], cues[j])
+	})
+	return cues
+}
+
+// cueLess orders cue numbers numerically, falling back to text for anything that is not a number
+func cueLess(a string, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return x < y
+}
+
+// nextCue returns the first mapped cue after the given one, or the first cue if none has run
+func (m *OSCMap) nextCue(current string) string {
+	cues := cueOrder(m.settings().controlMap)
+	if len(cues) == 0 {
+		return ""
+	}
+	if current == "" {
+		return cues[0]
+	}
+	value, err := strconv.ParseFloat(current, 64)
+	if err != nil {
+		return ""
+	}
+	for _, cue := range cues {
+		if v, err := strconv.ParseFloat(cue, 64); err == nil && v > value {
+			return cue
+		}
+	}
+	return ""
+}
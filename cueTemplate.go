@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"text/template"
+	"time"
+)
+
+// cueTemplateData is what the templates of cue actions can use, e.g. {"scene": "{{.Cue}}"}
+type cueTemplateData struct {
+	Cue  string
+	Time string
+}
+
+func newCueTemplateData(cueNumber string) cueTemplateData {
+	return cueTemplateData{Cue: cueNumber, Time: time.Now().Format(time.RFC3339)}
+}
+
+// cueTemplateFuncs let templates read secrets such as API keys from the environment with {{env "NAME"}}
+var cueTemplateFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// parseCueTemplate parses a template for a cue action, so that mistakes are found when the config is read
+func parseCueTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(cueTemplateFuncs).Option("missingkey=error").Parse(text)
+}
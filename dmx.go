@@ -0,0 +1,544 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dmxProtocol is the network protocol the DMX output speaks
+type dmxProtocol string
+
+const (
+	ProtocolSACN   dmxProtocol = "sacn"
+	ProtocolArtNet dmxProtocol = "artnet"
+)
+
+// dmxMerge decides how osc-map's levels combine with the lightboard's on the same universe
+type dmxMerge string
+
+const (
+	MergeHTP      dmxMerge = "htp"
+	MergeLTP      dmxMerge = "ltp"
+	MergePriority dmxMerge = "priority"
+)
+
+const (
+	DefaultDMXUniverse   = 1
+	DefaultDMXChannels   = "rgbw"
+	DefaultDMXSourceName = "osc-map"
+	DefaultDMXPriority   = 100
+	DefaultDMXRate       = 40 // frames per second
+	DefaultArtNetAddress = "255.255.255.255"
+
+	// a source that has sent nothing for this long is treated as gone, as in E1.31
+	dmxSourceTimeout = 2500 * time.Millisecond
+)
+
+// newDMXPatch validates a fixture's universe, start address and channel layout
+func newDMXPatch(cf confFixture) (*dmxPatch, error) {
+	universe := DefaultDMXUniverse
+	if cf.Universe != nil {
+		universe = *cf.Universe
+	}
+	channels := strings.ToLower(cf.Channels)
+	if channels == "" {
+		channels = DefaultDMXChannels
+	}
+
+	if universe < 0 || universe > 63999 {
+		return nil, fmt.Errorf("universe %d is out of range", universe)
+	}
+	for _, c := range channels {
+		if !strings.ContainsRune("rgbwd", c) {
+			return nil, fmt.Errorf("unknown channel %q in %q, use r, g, b, w or d", c, cf.Channels)
+		}
+	}
+	if cf.Address < 1 || cf.Address+len(channels)-1 > dmxSlots {
+		return nil, fmt.Errorf("address %d does not fit %d channels in a universe", cf.Address, len(channels))
+	}
+
+	return &dmxPatch{
+		universe: uint16(universe),
+		address:  cf.Address,
+		channels: channels,
+	}, nil
+}
+
+// values converts an RGBW color into the fixture's channels. Fixtures without a white channel have it folded into red, green and blue.
+func (p *dmxPatch) values(rgbw []int) []byte {
+	r, g, b, w := rgbw[0], rgbw[1], rgbw[2], rgbw[3]
+	if !strings.ContainsRune(p.channels, 'w') {
+		rgb := foldWhite(rgbw)
+		r, g, b = rgb[0], rgb[1], rgb[2]
+	}
+
+	values := make([]byte, len(p.channels))
+	for i, c := range p.channels {
+		switch c {
+		case 'r':
+			values[i] = byte(r)
+		case 'g':
+			values[i] = byte(g)
+		case 'b':
+			values[i] = byte(b)
+		case 'w':
+			values[i] = byte(w)
+		case 'd':
+			values[i] = byte(colorBrightness(rgbw))
+		}
+	}
+	return values
+}
+
+// dmxLevel is the color osc-map is sending a fixture, fading from one color to the next
+type dmxLevel struct {
+	from     []int
+	to       []int
+	start    time.Time
+	fade     time.Duration
+	released bool
+}
+
+// at returns the color partway through the fade
+func (l *dmxLevel) at(t time.Time) []int {
+	if l.fade <= 0 || t.Sub(l.start) >= l.fade {
+		return l.to
+	}
+
+	progress := float64(t.Sub(l.start)) / float64(l.fade)
+	color := make([]int, len(l.to))
+	for i := range color {
+		color[i] = int(math.Round(float64(l.from[i]) + (float64(l.to[i])-float64(l.from[i]))*progress))
+	}
+	return color
+}
+
+// dmxInput is the last universe received from the lightboard
+type dmxInput struct {
+	data     [dmxSlots]byte
+	changed  [dmxSlots]time.Time
+	priority byte
+	received time.Time
+}
+
+// dmxOutput sends the DMX fixtures' levels over sACN or Art-Net, merged with the lightboard's own stream
+type dmxOutput struct {
+	protocol    dmxProtocol
+	merge       dmxMerge
+	destination net.IP
+	port        int
+	sourceName  string
+	priority    byte
+	cid         [16]byte
+	conn        *net.UDPConn
+	fixtures    func() *fixturePatch
+	lights      *lightQueue
+
+	mutex    sync.Mutex
+	levels   map[string]*dmxLevel
+	inputs   map[uint16]*dmxInput
+	sequence map[uint16]byte
+	local    []net.IP
+	joined   map[uint16]bool // sACN universes being listened to, nil when not listening
+}
+
+// newDMXOutput opens the network connection described by outputs.dmx and starts sending frames for every universe in the fixture patch
+func newDMXOutput(c confDMX, fixtures func() *fixturePatch) (*dmxOutput, error) {
+	d := &dmxOutput{
+		protocol:   dmxProtocol(strings.ToLower(c.Protocol)),
+		merge:      dmxMerge(strings.ToLower(c.Merge)),
+		port:       c.Port,
+		sourceName: c.SourceName,
+		fixtures:   fixtures,
+		levels:     make(map[string]*dmxLevel),
+		inputs:     make(map[uint16]*dmxInput),
+		sequence:   make(map[uint16]byte),
+	}
+	if d.merge == "" {
+		d.merge = MergeHTP
+	}
+	if d.sourceName == "" {
+		d.sourceName = DefaultDMXSourceName
+	}
+
+	priority := c.Priority
+	if priority == 0 {
+		priority = DefaultDMXPriority
+	}
+	if priority < 0 || priority > 200 {
+		return nil, fmt.Errorf("priority must be between 0 and 200")
+	}
+	d.priority = byte(priority)
+
+	rate := c.Rate
+	if rate <= 0 {
+		rate = DefaultDMXRate
+	}
+
+	switch d.merge {
+	case MergeHTP, MergeLTP, MergePriority:
+	default:
+		return nil, fmt.Errorf("unknown merge mode %q", c.Merge)
+	}
+
+	if c.Destination != "" {
+		d.destination = net.ParseIP(c.Destination)
+		if d.destination == nil {
+			return nil, fmt.Errorf("invalid destination %q", c.Destination)
+		}
+	}
+
+	if err := d.fixtures().checkUniverses(d.protocol); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch d.protocol {
+	case ProtocolSACN:
+		if d.port == 0 {
+			d.port = SACNPort
+		}
+		if _, err := rand.Read(d.cid[:]); err != nil {
+			return nil, fmt.Errorf("cannot create sACN CID: %v", err)
+		}
+		d.conn, err = net.ListenUDP("udp4", nil)
+	case ProtocolArtNet:
+		if d.port == 0 {
+			d.port = ArtNetPort
+		}
+		if d.destination == nil {
+			d.destination = net.ParseIP(DefaultArtNetAddress)
+		}
+		// the lightboard's packets arrive on the Art-Net port, so when listening osc-map sends from it too
+		var local *net.UDPAddr
+		if c.Listen {
+			local = &net.UDPAddr{Port: d.port}
+		}
+		d.conn, err = net.ListenUDP("udp4", local)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, use sacn or artnet", c.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s socket: %v", d.protocol, err)
+	}
+
+	d.lights = newLightQueue(0, d.set)
+
+	if c.Listen {
+		d.listen()
+	}
+	go d.run(time.Duration(float32(time.Second) / rate))
+
+	return d, nil
+}
+
+// universes lists every universe with a DMX fixture patched in
+func (d *dmxOutput) universes() []uint16 {
+	patch := d.fixtures()
+
+	seen := make(map[uint16]bool)
+	var universes []uint16
+	for _, number := range patch.numbers {
+		f := patch.byNumber[number]
+		if f.dmx != nil && !seen[f.dmx.universe] {
+			seen[f.dmx.universe] = true
+			universes = append(universes, f.dmx.universe)
+		}
+	}
+	sort.Slice(universes, func(i, j int) bool {
+		return universes[i] < universes[j]
+	})
+	return universes
+}
+
+// checkUniverses makes sure every patched universe can be sent with the protocol, so that a reload with a
+// universe the output cannot send is rejected like any other config error
+func (p *fixturePatch) checkUniverses(protocol dmxProtocol) error {
+	for _, number := range p.numbers {
+		f := p.byNumber[number]
+		if f.dmx == nil {
+			continue
+		}
+		if protocol == ProtocolSACN && f.dmx.universe == 0 {
+			return fmt.Errorf("fixture %d: universe 0 cannot be used with sACN", number)
+		}
+		if protocol == ProtocolArtNet && f.dmx.universe > 0x7fff {
+			return fmt.Errorf("fixture %d: universe %d is above the Art-Net limit of 32767", number, f.dmx.universe)
+		}
+	}
+	return nil
+}
+
+// receiving lists the universes that are being received from the lightboard
+func (d *dmxOutput) receiving() []uint16 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	var universes []uint16
+	for universe := range d.inputs {
+		if d.input(universe, now) != nil {
+			universes = append(universes, universe)
+		}
+	}
+	sort.Slice(universes, func(i, j int) bool {
+		return universes[i] < universes[j]
+	})
+	return universes
+}
+
+// set starts a fade to the step's color. There is no network round trip, so the light queue never waits on it.
+func (d *dmxOutput) set(f *fixture, step lightStep) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	l, ok := d.levels[f.entity]
+	if !ok {
+		l = &dmxLevel{from: []int{0, 0, 0, 0}, to: []int{0, 0, 0, 0}}
+		d.levels[f.entity] = l
+	}
+
+	switch step.effect {
+	case "Light Board Control":
+		l.released = true
+		return nil
+	case "None", "":
+	default:
+		log.Warnf("House light %v cannot run effect %q over %s, setting the color only", f, step.effect, d.protocol)
+	}
+
+	l.from = l.at(now)
+	if l.released {
+		l.from = l.to
+	}
+	l.to = step.rgbw
+	if step.brightnessOnly {
+		l.to = withBrightness(l.from, step.rgbw)
+	}
+	l.start = now
+	l.fade = time.Duration(step.transition * float32(time.Second))
+	l.released = false
+
+	return nil
+}
+
+// run sends a frame for every universe at the frame rate. Receivers expect a constant stream, even when nothing changes.
+func (d *dmxOutput) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.sendFrames()
+	}
+}
+
+func (d *dmxOutput) sendFrames() {
+	patch := d.fixtures()
+	now := time.Now()
+	frames := make(map[uint16]*[dmxSlots]byte)
+
+	d.mutex.Lock()
+	for _, number := range patch.numbers {
+		f := patch.byNumber[number]
+		if f.dmx == nil {
+			continue
+		}
+
+		frame, ok := frames[f.dmx.universe]
+		if !ok {
+			frame = new([dmxSlots]byte)
+			if input := d.input(f.dmx.universe, now); input != nil {
+				*frame = input.data
+			}
+			frames[f.dmx.universe] = frame
+		}
+
+		l, ok := d.levels[f.entity]
+		if !ok || l.released {
+			continue
+		}
+		d.mergeFixture(frame, f, l, now)
+	}
+
+	var packets [][]byte
+	var addrs []*net.UDPAddr
+	for universe, frame := range frames {
+		sequence := d.sequence[universe]
+		d.sequence[universe] = sequence + 1
+
+		addr := &net.UDPAddr{IP: d.destination, Port: d.port}
+		if d.protocol == ProtocolSACN {
+			if addr.IP == nil {
+				addr.IP = sacnMulticastAddr(universe)
+			}
+			packets = append(packets, encodeSACN(d.cid, d.sourceName, d.priority, sequence, universe, frame))
+		} else {
+			packets = append(packets, encodeArtDmx(sequence, universe, frame))
+		}
+		addrs = append(addrs, addr)
+	}
+	d.mutex.Unlock()
+
+	for i, packet := range packets {
+		if _, err := d.conn.WriteToUDP(packet, addrs[i]); err != nil {
+			log.Errorf("Failed to send %s to %v: %v", d.protocol, addrs[i], err)
+		}
+	}
+}
+
+// input returns the lightboard's universe if it is still being received, which must be called with the mutex held
+func (d *dmxOutput) input(universe uint16, now time.Time) *dmxInput {
+	input, ok := d.inputs[universe]
+	if !ok || now.Sub(input.received) > dmxSourceTimeout {
+		return nil
+	}
+	return input
+}
+
+// mergeFixture writes osc-map's levels for a fixture into the frame, which already holds the lightboard's levels
+func (d *dmxOutput) mergeFixture(frame *[dmxSlots]byte, f *fixture, l *dmxLevel, now time.Time) {
+	input := d.input(f.dmx.universe, now)
+
+	for i, value := range f.dmx.values(l.at(now)) {
+		slot := f.dmx.address - 1 + i
+
+		switch {
+		case input == nil:
+			frame[slot] = value
+		case d.merge == MergeHTP:
+			if value > frame[slot] {
+				frame[slot] = value
+			}
+		case d.merge == MergeLTP:
+			if !input.changed[slot].After(l.start) {
+				frame[slot] = value
+			}
+		case d.merge == MergePriority:
+			if d.protocol == ProtocolArtNet || d.priority >= input.priority {
+				frame[slot] = value
+			}
+		}
+	}
+}
+
+// listen receives the lightboard's stream for every patched universe so that it can be merged
+func (d *dmxOutput) listen() {
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				d.local = append(d.local, ipNet.IP)
+			}
+		}
+	}
+
+	if d.protocol == ProtocolArtNet {
+		go d.receive(d.conn)
+		return
+	}
+
+	d.joined = make(map[uint16]bool)
+	d.joinUniverses()
+}
+
+// joinUniverses listens for sACN on every patched universe that is not being listened to yet, such as
+// one added by a reload. Art-Net arrives on a single socket, so there is nothing to join.
+func (d *dmxOutput) joinUniverses() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.joined == nil {
+		return
+	}
+	for _, universe := range d.universes() {
+		if d.joined[universe] {
+			continue
+		}
+		conn, err := net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: sacnMulticastAddr(universe), Port: d.port})
+		if err != nil {
+			log.Errorf("Cannot listen for sACN universe %d: %v", universe, err)
+			continue
+		}
+		d.joined[universe] = true
+		go d.receive(conn)
+	}
+}
+
+func (d *dmxOutput) receive(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Errorf("Stopped receiving %s: %v", d.protocol, err)
+			return
+		}
+
+		if d.protocol == ProtocolSACN {
+			packet, ok := decodeSACN(buf[:n])
+			if !ok || packet.cid == d.cid {
+				continue
+			}
+			if packet.terminated {
+				d.mutex.Lock()
+				delete(d.inputs, packet.universe)
+				d.mutex.Unlock()
+				continue
+			}
+			d.receiveUniverse(packet.universe, packet.priority, packet.data)
+		} else {
+			if d.isLocal(from) {
+				continue
+			}
+			universe, data, ok := decodeArtDmx(buf[:n])
+			if !ok {
+				continue
+			}
+			d.receiveUniverse(universe, 0, data)
+		}
+	}
+}
+
+// isLocal reports whether an Art-Net packet is one of osc-map's own, looped back by the broadcast
+func (d *dmxOutput) isLocal(from *net.UDPAddr) bool {
+	if from.Port != d.port {
+		return false
+	}
+	for _, ip := range d.local {
+		if ip.Equal(from.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dmxOutput) receiveUniverse(universe uint16, priority byte, data []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	input, ok := d.inputs[universe]
+	if !ok {
+		input = &dmxInput{}
+		d.inputs[universe] = input
+		log.Infof("Receiving lightboard %s on universe %d", d.protocol, universe)
+	}
+
+	var data512 [dmxSlots]byte
+	copy(data512[:], data)
+	for i, value := range data512 {
+		if value != input.data[i] {
+			input.changed[i] = now
+		}
+	}
+	input.data = data512
+	input.priority = priority
+	input.received = now
+}
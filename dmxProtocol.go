@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	SACNPort   = 5568
+	ArtNetPort = 6454
+
+	dmxSlots = 512
+
+	sacnPacketLength   = 638
+	artDmxPacketLength = 18 + dmxSlots
+
+	sacnOptionPreview    = 0x80
+	sacnOptionTerminated = 0x40
+)
+
+var (
+	acnPacketIdentifier = []byte{'A', 'S', 'C', '-', 'E', '1', '.', '1', '7', 0, 0, 0}
+	artNetID            = []byte{'A', 'r', 't', '-', 'N', 'e', 't', 0}
+)
+
+const artOpDmx = 0x5000
+
+// sacnMulticastAddr is the multicast group E1.31 uses for a universe
+func sacnMulticastAddr(universe uint16) net.IP {
+	return net.IPv4(239, 255, byte(universe>>8), byte(universe))
+}
+
+// flagsAndLength packs the length of an E1.31 layer, counted from its own start, with the 0x7 flags
+func flagsAndLength(length int) uint16 {
+	return 0x7000 | uint16(length)
+}
+
+// encodeSACN builds an E1.31 data packet carrying a full universe
+func encodeSACN(cid [16]byte, sourceName string, priority byte, sequence byte, universe uint16, data *[dmxSlots]byte) []byte {
+	p := make([]byte, sacnPacketLength)
+
+	// root layer
+	binary.BigEndian.PutUint16(p[0:], 0x0010)
+	copy(p[4:16], acnPacketIdentifier)
+	binary.BigEndian.PutUint16(p[16:], flagsAndLength(sacnPacketLength-16))
+	binary.BigEndian.PutUint32(p[18:], 0x00000004)
+	copy(p[22:38], cid[:])
+
+	// framing layer
+	binary.BigEndian.PutUint16(p[38:], flagsAndLength(sacnPacketLength-38))
+	binary.BigEndian.PutUint32(p[40:], 0x00000002)
+	copy(p[44:107], sourceName) // always null terminated
+	p[108] = priority
+	p[111] = sequence
+	binary.BigEndian.PutUint16(p[113:], universe)
+
+	// DMP layer
+	binary.BigEndian.PutUint16(p[115:], flagsAndLength(sacnPacketLength-115))
+	p[117] = 0x02
+	p[118] = 0xa1
+	binary.BigEndian.PutUint16(p[121:], 0x0001)
+	binary.BigEndian.PutUint16(p[123:], dmxSlots+1)
+	copy(p[126:], data[:])
+
+	return p
+}
+
+// sacnPacket is the part of a received E1.31 data packet osc-map merges with
+type sacnPacket struct {
+	cid        [16]byte
+	universe   uint16
+	priority   byte
+	terminated bool
+	data       []byte
+}
+
+// decodeSACN reads an E1.31 data packet, returning false for anything else, previews, and alternate start codes
+func decodeSACN(p []byte) (sacnPacket, bool) {
+	var packet sacnPacket
+	if len(p) < 126 || !bytes.Equal(p[4:16], acnPacketIdentifier) {
+		return packet, false
+	}
+	if binary.BigEndian.Uint32(p[18:]) != 0x00000004 || binary.BigEndian.Uint32(p[40:]) != 0x00000002 {
+		return packet, false
+	}
+
+	options := p[112]
+	if options&sacnOptionPreview != 0 || p[125] != 0 {
+		return packet, false
+	}
+
+	slots := int(binary.BigEndian.Uint16(p[123:])) - 1
+	if slots < 0 || slots > dmxSlots || len(p) < 126+slots {
+		return packet, false
+	}
+
+	copy(packet.cid[:], p[22:38])
+	packet.universe = binary.BigEndian.Uint16(p[113:])
+	packet.priority = p[108]
+	packet.terminated = options&sacnOptionTerminated != 0
+	packet.data = p[126 : 126+slots]
+
+	return packet, true
+}
+
+// encodeArtDmx builds an Art-Net ArtDmx packet carrying a full universe, where the universe is the 15 bit port address
+func encodeArtDmx(sequence byte, universe uint16, data *[dmxSlots]byte) []byte {
+	p := make([]byte, artDmxPacketLength)
+
+	copy(p[0:8], artNetID)
+	binary.LittleEndian.PutUint16(p[8:], artOpDmx)
+	binary.BigEndian.PutUint16(p[10:], 14) // protocol version
+	p[12] = sequence
+	binary.LittleEndian.PutUint16(p[14:], universe&0x7fff)
+	binary.BigEndian.PutUint16(p[16:], dmxSlots)
+	copy(p[18:], data[:])
+
+	return p
+}
+
+// decodeArtDmx reads an ArtDmx packet, returning false for any other Art-Net packet
+func decodeArtDmx(p []byte) (universe uint16, data []byte, ok bool) {
+	if len(p) < 18 || !bytes.Equal(p[0:8], artNetID) || binary.LittleEndian.Uint16(p[8:]) != artOpDmx {
+		return 0, nil, false
+	}
+
+	length := int(binary.BigEndian.Uint16(p[16:]))
+	if length > dmxSlots || len(p) < 18+length {
+		return 0, nil, false
+	}
+
+	return binary.LittleEndian.Uint16(p[14:]) & 0x7fff, p[18 : 18+length], true
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// effectType is the generator used to produce the steps of a custom effect
+type effectType string
+
+const (
+	EffectSteps    effectType = "steps"
+	EffectChase    effectType = "chase"
+	EffectFadeLoop effectType = "fade-loop"
+	EffectRandom   effectType = "random"
+	EffectFlicker  effectType = "flicker"
+	EffectPulse    effectType = "pulse"
+)
+
+// rainbowColors are the colors of the original compiled-in Custom Rainbow
+var rainbowColors = []colorSpec{
+	rgbwSpec(255, 0, 0, 0),
+	rgbwSpec(255, 128, 0, 0),
+	rgbwSpec(255, 255, 0, 0),
+	rgbwSpec(128, 255, 0, 0),
+	rgbwSpec(0, 255, 0, 0),
+	rgbwSpec(0, 255, 128, 0),
+	rgbwSpec(0, 255, 255, 0),
+	rgbwSpec(0, 128, 255, 0),
+	rgbwSpec(0, 0, 255, 0),
+	rgbwSpec(128, 0, 255, 0),
+	rgbwSpec(255, 0, 255, 0),
+	rgbwSpec(255, 0, 128, 0),
+}
+
+// defaultCustomEffects are always available unless a config defines an effect with the same name
+func defaultCustomEffects() map[string]confCustomEffect {
+	return map[string]confCustomEffect{
+		"Custom Rainbow": {
+			Type:   string(EffectRandom),
+			Colors: rainbowColors,
+		},
+	}
+}
+
+// effectDirection orders the lights of an effect for its phase offset
+type effectDirection string
+
+const (
+	DirectionCueOrder    effectDirection = "cue-order"
+	DirectionFrontToBack effectDirection = "front-to-back"
+	DirectionBackToFront effectDirection = "back-to-front"
+	DirectionLeftToRight effectDirection = "left-to-right"
+	DirectionRightToLeft effectDirection = "right-to-left"
+)
+
+// effectScope decides whether each light's effect is stopped on its own or with the rest of its group
+type effectScope string
+
+const (
+	ScopeLight effectScope = "light"
+	ScopeGroup effectScope = "group"
+)
+
+// effectStep is a single color change within an effect, fading over the transition and lasting for the interval
+type effectStep struct {
+	rgbw       []int
+	transition float32
+	interval   float32
+}
+
+// customEffect is a validated effect definition
+type customEffect struct {
+	name        string
+	kind        effectType
+	colors      [][]int
+	steps       []effectStep
+	interval    float32
+	transition  *float32
+	period      float32
+	depth       float32
+	phaseOffset float32
+	direction   effectDirection
+	scope       effectScope
+	seed        uint64
+}
+
+// buildCustomEffects validates the custom-effects config on top of the defaults
+func buildCustomEffects(confEffects map[string]confCustomEffect) (map[string]*customEffect, error) {
+	all := defaultCustomEffects()
+	for name, ce := range confEffects {
+		all[name] = ce
+	}
+
+	effects := make(map[string]*customEffect)
+	for name, ce := range all {
+		e := &customEffect{
+			name:        name,
+			kind:        effectType(ce.Type),
+			interval:    ce.Interval,
+			transition:  ce.Transition,
+			period:      ce.Period,
+			depth:       ce.Depth,
+			phaseOffset: ce.PhaseOffset,
+			direction:   effectDirection(ce.Direction),
+			scope:       effectScope(ce.Scope),
+			seed:        ce.Seed,
+		}
+		if e.direction == "" {
+			e.direction = DirectionCueOrder
+		}
+		if e.scope == "" {
+			e.scope = ScopeLight
+		}
+
+		switch e.direction {
+		case DirectionCueOrder, DirectionFrontToBack, DirectionBackToFront, DirectionLeftToRight, DirectionRightToLeft:
+		default:
+			return nil, fmt.Errorf("effect %q has unknown direction %q", name, ce.Direction)
+		}
+		if e.scope != ScopeLight && e.scope != ScopeGroup {
+			return nil, fmt.Errorf("effect %q has unknown scope %q", name, ce.Scope)
+		}
+
+		switch e.kind {
+		case EffectSteps:
+			if len(ce.Steps) == 0 {
+				return nil, fmt.Errorf("effect %q needs steps", name)
+			}
+			for _, s := range ce.Steps {
+				rgbw, err := s.RGBW.rgbw()
+				if err != nil {
+					return nil, fmt.Errorf("effect %q step: %v", name, err)
+				}
+				if s.Interval <= 0 {
+					return nil, fmt.Errorf("effect %q step needs an interval", name)
+				}
+				e.steps = append(e.steps, effectStep{rgbw: rgbw, transition: s.Transition, interval: s.Interval})
+			}
+		case EffectChase, EffectFadeLoop, EffectRandom, EffectFlicker, EffectPulse:
+			if len(ce.Colors) == 0 {
+				return nil, fmt.Errorf("effect %q needs colors", name)
+			}
+		default:
+			return nil, fmt.Errorf("effect %q has unknown type %q", name, ce.Type)
+		}
+
+		for _, color := range ce.Colors {
+			rgbw, err := color.rgbw()
+			if err != nil {
+				return nil, fmt.Errorf("effect %q color: %v", name, err)
+			}
+			e.colors = append(e.colors, rgbw)
+		}
+		if e.depth < 0 || e.depth > 1 {
+			return nil, fmt.Errorf("effect %q depth must be between 0 and 1", name)
+		}
+		if e.kind == EffectPulse && e.period <= 0 {
+			return nil, fmt.Errorf("effect %q needs a period", name)
+		}
+
+		effects[name] = e
+	}
+
+	return effects, nil
+}
+
+// scaleColor dims a color by a factor from 0 to 1
+func scaleColor(rgbw []int, factor float64) []int {
+	scaled := make([]int, len(rgbw))
+	for i, c := range rgbw {
+		scaled[i] = clampColor(int(math.Round(float64(c) * factor)))
+	}
+	return scaled
+}
+
+// minEffectInterval is the shortest step of an effect that follows the cue's transition, that of the
+// original Custom Rainbow on a cue with no transition
+const minEffectInterval = 0.1
+
+// timing returns the interval and transition of each step. Effects without their own timing follow
+// the cue's transition, as the original Custom Rainbow did.
+func (e *customEffect) timing(cueTransition float32) (interval float32, transition float32) {
+	interval = e.interval
+	if interval <= 0 {
+		interval = cueTransition + 0.1
+		if interval < minEffectInterval {
+			interval = minEffectInterval
+		}
+	}
+
+	if e.transition != nil {
+		transition = *e.transition
+	} else if e.kind == EffectFadeLoop || e.kind == EffectPulse {
+		transition = interval
+	} else if e.interval <= 0 {
+		transition = cueTransition - 0.1
+	}
+	if transition < 0 {
+		transition = 0
+	}
+
+	return interval, transition
+}
+
+// step generates the nth step of the effect
+func (e *customEffect) step(n int, rng *rand.Rand, cueTransition float32) effectStep {
+	if e.kind == EffectSteps {
+		return e.steps[n%len(e.steps)]
+	}
+
+	interval, transition := e.timing(cueTransition)
+	s := effectStep{transition: transition, interval: interval}
+
+	switch e.kind {
+	case EffectChase, EffectFadeLoop:
+		s.rgbw = e.colors[n%len(e.colors)]
+	case EffectRandom:
+		s.rgbw = e.colors[rng.IntN(len(e.colors))]
+	case EffectFlicker:
+		depth := float64(e.depth)
+		if depth == 0 {
+			depth = 0.5
+		}
+		base := e.colors[rng.IntN(len(e.colors))]
+		s.rgbw = scaleColor(base, 1-depth*rng.Float64())
+	case EffectPulse:
+		depth := float64(e.depth)
+		if depth == 0 {
+			depth = 1
+		}
+		elapsed := float64(n) * float64(interval)
+		wave := (1 + math.Cos(2*math.Pi*elapsed/float64(e.period))) / 2
+		base := e.colors[int(elapsed/float64(e.period))%len(e.colors)]
+		s.rgbw = scaleColor(base, 1-depth*(1-wave))
+	}
+
+	return s
+}
+
+// offsets returns how many seconds each light's effect is delayed by. Lights in the same row, or column,
+// share an offset so that the effect moves across the house as a wave.
+func (e *customEffect) offsets(lights []*fixture) []float32 {
+	offsets := make([]float32, len(lights))
+	if len(lights) == 0 {
+		return offsets
+	}
+
+	position := func(i int) int {
+		switch e.direction {
+		case DirectionFrontToBack, DirectionBackToFront:
+			return lights[i].row
+		case DirectionLeftToRight, DirectionRightToLeft:
+			return lights[i].column
+		}
+		return i
+	}
+
+	first, last := position(0), position(0)
+	for i := range lights {
+		if position(i) < first {
+			first = position(i)
+		}
+		if position(i) > last {
+			last = position(i)
+		}
+	}
+
+	for i := range lights {
+		steps := position(i) - first
+		if e.direction == DirectionBackToFront || e.direction == DirectionRightToLeft {
+			steps = last - position(i)
+		}
+		offsets[i] = e.phaseOffset * float32(steps)
+	}
+
+	return offsets
+}
+
+// newRand seeds an effect's random source per fixture, so a seeded effect repeats exactly but
+// lights running the same effect do not all pick the same colors
+func (e *customEffect) newRand(lightID int) *rand.Rand {
+	seed := e.seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	return rand.New(rand.NewPCG(seed, uint64(lightID)))
+}
+
+// runEffect sends the effect's steps to a light until the stop channel is closed. Every step is timed
+// from the shared start time plus the light's offset, rather than from the last step, so that lights
+// running the same effect stay in step no matter how long each request takes.
+func (m *OSCMap) runEffect(f *fixture, e *customEffect, start time.Time, offset float32, cueTransition float32, stopChannel <-chan struct{}) {
+	next := start.Add(time.Duration(offset * float32(time.Second)))
+	sleep := func() bool {
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+
+		select {
+		case <-stopChannel:
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	if !sleep() {
+		return
+	}
+
+	rng := e.newRand(f.number)
+	for n := 0; ; n++ {
+		s := e.step(n, rng, cueTransition)
+
+		// Steps that Home Assistant cannot keep up with are dropped by the light's queue rather than piling up
+		m.queueLight(f, "effect "+e.name, stopChannel, lightStep{rgbw: s.rgbw, transition: s.transition, effect: "None"})
+
+		next = next.Add(time.Duration(s.interval * float32(time.Second)))
+		if !sleep() {
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const eventBuffer = 64 // events a slow subscriber may fall behind by before it misses some
+
+// Event is something that happened in osc-map, sent to the API's event stream
+type Event struct {
+	Type       string            `json:"type"` // cue, output, reload or connection
+	Time       time.Time         `json:"time"`
+	Cue        string            `json:"cue,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Output     *OutputResult     `json:"output,omitempty"`
+	Show       string            `json:"show,omitempty"`
+	Connection *ConnectionStatus `json:"connection,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// eventHub sends every event to each subscriber. A subscriber that stops reading misses events rather than holding up a cue.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+var events = &eventHub{subscribers: make(map[chan Event]struct{})}
+
+func (h *eventHub) subscribe() chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, eventBuffer)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+func (h *eventHub) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// errorText returns the error's message, or nothing if there was no error
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
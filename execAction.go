@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// execAction is a program run when a cue fires
+type execAction struct {
+	command       string
+	args          []string
+	env           []string
+	dir           string
+	timeout       time.Duration
+	detach        bool
+	killOnAllStop bool
+}
+
+// buildExecActions checks a cue's exec list. The command itself is only looked up when it runs, as it may not exist yet.
+func buildExecActions(actions []confExecAction) ([]execAction, error) {
+	var built []execAction
+	for i, a := range actions {
+		if a.Command == "" {
+			return nil, fmt.Errorf("exec action %d needs a command", i+1)
+		}
+		if a.Timeout < 0 {
+			return nil, fmt.Errorf("exec %q has a negative timeout", a.Command)
+		}
+		if a.Dir != "" {
+			if info, err := os.Stat(a.Dir); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("exec %q has a dir %q that is not a directory", a.Command, a.Dir)
+			}
+		}
+
+		keys := make([]string, 0, len(a.Env))
+		for key := range a.Env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		env := make([]string, len(keys))
+		for j, key := range keys {
+			env[j] = key + "=" + a.Env[key]
+		}
+
+		built = append(built, execAction{
+			command:       a.Command,
+			args:          a.Args,
+			env:           env,
+			dir:           a.Dir,
+			timeout:       time.Duration(a.Timeout * float32(time.Second)),
+			detach:        a.Detach,
+			killOnAllStop: a.KillOnAllStop,
+		})
+	}
+	return built, nil
+}
+
+// process is a program started by a cue that has not exited yet
+type process struct {
+	id            int
+	cue           string
+	name          string
+	cmd           *exec.Cmd
+	killOnAllStop bool
+}
+
+// processRegistry keeps track of running programs so that an all stop can kill them
+type processRegistry struct {
+	mu     sync.Mutex
+	nextID int
+	active map[int]*process
+}
+
+var processes = &processRegistry{active: make(map[int]*process)}
+
+func (r *processRegistry) add(p *process) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	p.id = r.nextID
+	r.active[p.id] = p
+}
+
+func (r *processRegistry) remove(p *process) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.active, p.id)
+}
+
+// killAll kills every running program that was started with kill-on-all-stop
+func (r *processRegistry) killAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.active {
+		if !p.killOnAllStop {
+			continue
+		}
+		log.Infof("Killing %s from cue[%v]", p.name, p.cue)
+		if err := killProcess(p.cmd); err != nil {
+			log.Errorf("Failed to kill %s from cue[%v]: %v", p.name, p.cue, err)
+		}
+	}
+}
+
+// runExec runs the cue's programs in order. A program that is not detached is waited for before the next one starts.
+func (m *OSCMap) runExec(cueNumber string, cueInteger string) error {
+	controlMap := m.settings().controlMap
+	mc, ok := controlMap[cueNumber]
+	if !ok {
+		mc, ok = controlMap[cueInteger]
+		if !ok {
+			log.Debugf("No exec actions for cue[%v]", cueNumber)
+			return nil
+		}
+	}
+
+	var errs []error
+	for _, a := range mc.exec {
+		if a.detach {
+			go a.run(cueNumber)
+		} else if err := a.run(cueNumber); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run starts the program and logs its output against the cue until it exits or times out
+func (a execAction) run(cueNumber string) error {
+	ctx := context.Background()
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, a.command, a.args...)
+	cmd.Dir = a.dir
+	cmd.Env = append(append(os.Environ(), "OSC_MAP_CUE="+cueNumber), a.env...)
+	setProcessGroup(cmd)
+	// the context kills the whole group, so that a script's children do not outlive it
+	cmd.Cancel = func() error {
+		return killProcess(cmd)
+	}
+	// a child that outlives the program and holds its output open is not waited for
+	cmd.WaitDelay = time.Second
+
+	output, writer := io.Pipe()
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+
+	name := strings.Join(append([]string{a.command}, a.args...), " ")
+	if err := cmd.Start(); err != nil {
+		return cueError("Failed to run %s for cue[%v]: %v", name, cueNumber, err)
+	}
+	log.Infof("Started %s for cue[%v] with pid %d", name, cueNumber, cmd.Process.Pid)
+
+	p := &process{cue: cueNumber, name: name, cmd: cmd, killOnAllStop: a.killOnAllStop}
+	processes.add(p)
+	defer processes.remove(p)
+
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			log.Infof("cue[%v] %s: %s", cueNumber, a.command, scanner.Text())
+		}
+		io.Copy(io.Discard, output)
+	}()
+
+	err := cmd.Wait()
+	writer.Close()
+	<-logged
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return cueError("killed %s for cue[%v] after %v", name, cueNumber, a.timeout)
+	case errors.As(err, &exitErr):
+		return cueError("%s for cue[%v] exited with %v", name, cueNumber, exitErr)
+	case err != nil:
+		return cueError("%s for cue[%v] failed: %v", name, cueNumber, err)
+	}
+	log.Infof("%s for cue[%v] finished", name, cueNumber)
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fixtureMode is the kind of color a house light fixture accepts
+type fixtureMode string
+
+const (
+	FixtureRGBW      fixtureMode = "rgbw"
+	FixtureRGB       fixtureMode = "rgb"
+	FixtureColorTemp fixtureMode = "color-temp"
+	FixtureDimmer    fixtureMode = "dimmer"
+)
+
+const (
+	DefaultNumHouseLights = 15
+	DefaultFixtureEntity  = "light.house_light_%d"
+
+	minColorTempKelvin = 2700
+	maxColorTempKelvin = 6500
+)
+
+// fixture is a single house light that osc-map can control
+type fixture struct {
+	number  int
+	name    string
+	entity  string
+	mode    fixtureMode
+	effects bool // whether the fixture has the ESPHome effects, e.g. "Light Board Control"
+
+	// position in the house, rows counting from the stage and columns from house left
+	row    int
+	column int
+
+	// set when the fixture is driven directly over sACN or Art-Net rather than through Home Assistant
+	dmx *dmxPatch
+}
+
+// dmxPatch is where a fixture sits in the DMX universes and the order of its channels
+type dmxPatch struct {
+	universe uint16
+	address  int // 1-512
+	channels string
+}
+
+// fixturePatch holds every configured fixture, looked up by number, name or entity, and the named groups of them
+type fixturePatch struct {
+	numbers  []int
+	byNumber map[int]*fixture
+	byName   map[string]*fixture
+	byEntity map[string]*fixture
+	groups   map[string][]*fixture
+}
+
+// defaultFixtures recreates the original 15 RGBW house lights for configs without a fixtures section
+func defaultFixtures() []confFixture {
+	fixtures := make([]confFixture, DefaultNumHouseLights)
+	for i := range fixtures {
+		row, column := defaultPosition(i + 1)
+		fixtures[i] = confFixture{Number: i + 1, Row: row, Column: column}
+	}
+	return fixtures
+}
+
+// defaultPosition places the original house lights by the layout table, four to a row with the booth
+// taking the first column of the last row
+func defaultPosition(number int) (row int, column int) {
+	if number > 12 {
+		return 4, number - 11
+	}
+	return (number-1)/4 + 1, (number-1)%4 + 1
+}
+
+// newFixturePatch validates the fixtures and groups config and fills in defaults
+func newFixturePatch(confFixtures []confFixture, groups map[string][]string) (*fixturePatch, error) {
+	if len(confFixtures) == 0 {
+		confFixtures = defaultFixtures()
+		if groups == nil {
+			groups = defaultGroups()
+		}
+	}
+
+	patch := &fixturePatch{
+		byNumber: make(map[int]*fixture),
+		byName:   make(map[string]*fixture),
+		byEntity: make(map[string]*fixture),
+		groups:   make(map[string][]*fixture),
+	}
+
+	for _, cf := range confFixtures {
+		if cf.Number < 1 {
+			return nil, fmt.Errorf("fixture %q needs a number of 1 or more", cf.Name)
+		}
+		if _, ok := patch.byNumber[cf.Number]; ok {
+			return nil, fmt.Errorf("fixture number %d is used more than once", cf.Number)
+		}
+
+		f := &fixture{
+			number: cf.Number,
+			name:   cf.Name,
+			entity: cf.Entity,
+			mode:   fixtureMode(strings.ToLower(cf.Mode)),
+			row:    cf.Row,
+			column: cf.Column,
+		}
+		if f.entity == "" {
+			f.entity = fmt.Sprintf(DefaultFixtureEntity, f.number)
+		}
+		if f.mode == "" {
+			f.mode = FixtureRGBW
+		}
+
+		switch f.mode {
+		case FixtureRGBW, FixtureRGB, FixtureColorTemp, FixtureDimmer:
+		default:
+			return nil, fmt.Errorf("fixture %d has unknown mode %q", f.number, cf.Mode)
+		}
+
+		if cf.Address != 0 {
+			dmx, err := newDMXPatch(cf)
+			if err != nil {
+				return nil, fmt.Errorf("fixture %d: %v", f.number, err)
+			}
+			f.dmx = dmx
+		}
+
+		// only the RGBW bulbs are flashed with the lightboard effects unless told otherwise
+		f.effects = f.mode == FixtureRGBW
+		if cf.Effects != nil {
+			f.effects = *cf.Effects
+		}
+
+		if _, ok := patch.byEntity[f.entity]; ok {
+			return nil, fmt.Errorf("entity %s is used by more than one fixture", f.entity)
+		}
+		if f.name != "" {
+			if _, ok := patch.byName[strings.ToLower(f.name)]; ok {
+				return nil, fmt.Errorf("fixture name %q is used more than once", f.name)
+			}
+			patch.byName[strings.ToLower(f.name)] = f
+		}
+
+		patch.numbers = append(patch.numbers, f.number)
+		patch.byNumber[f.number] = f
+		patch.byEntity[f.entity] = f
+	}
+
+	if err := patch.addGroups(groups); err != nil {
+		return nil, err
+	}
+
+	return patch, nil
+}
+
+// defaultGroups follows the house layout of the original 15 house lights
+func defaultGroups() map[string][]string {
+	return map[string][]string{
+		"front": {"1", "2", "3", "4"},
+		"joist": {"5", "6", "7", "8", "9", "10", "11", "12"},
+		"booth": {"13", "14", "15"},
+	}
+}
+
+// addGroups validates the named groups. The "all" group is always defined.
+func (p *fixturePatch) addGroups(groups map[string][]string) error {
+	for name, refs := range groups {
+		key := strings.ToLower(name)
+		if key == "all" {
+			return fmt.Errorf("group name \"all\" is reserved")
+		}
+		if _, ok := p.byName[key]; ok {
+			return fmt.Errorf("group %q has the same name as a fixture", name)
+		}
+
+		members := make([]*fixture, 0, len(refs))
+		for _, ref := range refs {
+			f, err := p.resolve(ref)
+			if err != nil {
+				return fmt.Errorf("group %q: %v", name, err)
+			}
+			members = append(members, f)
+		}
+		p.groups[key] = members
+	}
+
+	all := make([]*fixture, 0, len(p.numbers))
+	for _, number := range p.numbers {
+		all = append(all, p.byNumber[number])
+	}
+	p.groups["all"] = all
+
+	return nil
+}
+
+// resolveAll looks up the fixtures for a houselights entry, which may be a fixture number, a fixture name or a group name
+func (p *fixturePatch) resolveAll(ref string) ([]*fixture, error) {
+	if group, ok := p.groups[strings.ToLower(ref)]; ok {
+		return group, nil
+	}
+
+	f, err := p.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return []*fixture{f}, nil
+}
+
+// resolve looks up a single fixture from its number or name
+func (p *fixturePatch) resolve(ref string) (*fixture, error) {
+	if number, err := strconv.Atoi(ref); err == nil {
+		f, ok := p.byNumber[number]
+		if !ok {
+			return nil, fmt.Errorf("no fixture number %d", number)
+		}
+		return f, nil
+	}
+
+	f, ok := p.byName[strings.ToLower(ref)]
+	if !ok {
+		return nil, fmt.Errorf("no fixture or group named %q", ref)
+	}
+	return f, nil
+}
+
+// String names the fixture for logs
+func (f *fixture) String() string {
+	if f.name != "" {
+		return fmt.Sprintf("%d (%s)", f.number, f.name)
+	}
+	return strconv.Itoa(f.number)
+}
+
+// lightRequest converts an RGBW color into the request data for the fixture's native mode
+func (f *fixture) lightRequest(rgbw []int, transition float32, effect string) LightRequestData {
+	data := LightRequestData{
+		Entity_id:  f.entity,
+		Transition: transition,
+	}
+	if f.effects {
+		data.Effect = effect
+	}
+
+	switch f.mode {
+	case FixtureRGBW:
+		data.Rgbw_color = rgbw
+	case FixtureRGB:
+		data.Rgb_color = foldWhite(rgbw)
+	case FixtureColorTemp:
+		brightness := colorBrightness(rgbw)
+		data.Brightness = &brightness
+		data.Color_temp_kelvin = rgbwToKelvin(rgbw, minColorTempKelvin, maxColorTempKelvin)
+	case FixtureDimmer:
+		brightness := colorBrightness(rgbw)
+		data.Brightness = &brightness
+	}
+
+	return data
+}
+
+// brightnessRequest changes only the brightness of the fixture, to the brightness of the RGBW color
+func (f *fixture) brightnessRequest(rgbw []int, transition float32, effect string) LightRequestData {
+	brightness := colorBrightness(rgbw)
+	data := LightRequestData{
+		Entity_id:  f.entity,
+		Transition: transition,
+		Brightness: &brightness,
+	}
+	if f.effects {
+		data.Effect = effect
+	}
+	return data
+}
+
+// clampColor limits a color channel to 0-255
+func clampColor(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// maxChannel returns the brightest channel of a color
+func maxChannel(color []int) int {
+	brightest := 0
+	for _, c := range color {
+		if c > brightest {
+			brightest = c
+		}
+	}
+	return brightest
+}
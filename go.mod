@@ -13,6 +13,7 @@ require (
 	github.com/micmonay/keybd_event v1.1.2
 	github.com/sirupsen/logrus v1.9.0
 	gitlab.com/gomidi/midi/v2 v2.0.25
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956
 )
 
 require (
@@ -22,5 +23,4 @@ require (
 	golang.org/x/exp v0.0.0-20190306152737-a1d7652674e8 // indirect
 	golang.org/x/image v0.0.0-20190227222117-0694c2d4d067 // indirect
 	golang.org/x/mobile v0.0.0-20190415191353-3e0bab5405d6 // indirect
-	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
 )
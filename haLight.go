@@ -1,235 +1,183 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"math/rand/v2"
-	"net/http"
-	"os"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func sendRequestJSON(lightID int, rgbw []int, transition float32, effect string) {
-	url := fmt.Sprintf("%s:%d/api/services/light/turn_on", DefaultHomeAssistantHTTP, DefaultHomeAssistantPort)
+// effectStop is closed to stop a custom effect. Group effects share one between all of their lights,
+// so that stopping any light stops the whole group.
+type effectStop struct {
+	ch   chan struct{}
+	once sync.Once
+}
 
-	data := LightRequestData{
-		Entity_id:  fmt.Sprintf("light.house_light_%d", lightID),
-		Rgbw_color: rgbw,
-		Transition: transition,
-		Effect:     effect,
-	}
+func newEffectStop() *effectStop {
+	return &effectStop{ch: make(chan struct{})}
+}
 
-	// Make client
-	client := &http.Client{}
+func (s *effectStop) stop() {
+	s.once.Do(func() {
+		close(s.ch)
+	})
+}
 
-	jsonData, err := json.Marshal(&data)
-	if err != nil {
-		log.Errorf("Unable to create json data: %v", err)
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Errorf("Error creating request: %v", err)
+// Each fixture needs a stop channel for custom effects, keyed by fixture number
+var stopChannels = make(map[int]*effectStop)
+
+// stopChannelsMutex guards stopChannels, which are replaced from several goroutines
+var stopChannelsMutex sync.Mutex
+
+// stopEffect stops any custom effect running on the light
+func stopEffect(lightID int) {
+	stopChannelsMutex.Lock()
+	defer stopChannelsMutex.Unlock()
+
+	if s, ok := stopChannels[lightID]; ok {
+		s.stop()
 	}
+	stopChannels[lightID] = newEffectStop()
+}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("HAKEY")))
-	req.Header.Set("Content-Type", "application/json")
+// effectStopChannel returns the channel that is closed when the light's effect should stop
+func effectStopChannel(lightID int) <-chan struct{} {
+	stopChannelsMutex.Lock()
+	defer stopChannelsMutex.Unlock()
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Errorf("Error sending request: %v", err)
+	s, ok := stopChannels[lightID]
+	if !ok {
+		s = newEffectStop()
+		stopChannels[lightID] = s
 	}
+	return s.ch
+}
+
+// startGroupEffect stops any custom effects running on the lights and gives them a single shared stop channel
+func startGroupEffect(lightIDs []int) <-chan struct{} {
+	stopChannelsMutex.Lock()
+	defer stopChannelsMutex.Unlock()
 
-	defer resp.Body.Close()
-	//fmt.Println("Response Status:", resp.Status)
+	shared := newEffectStop()
+	for _, lightID := range lightIDs {
+		if s, ok := stopChannels[lightID]; ok {
+			s.stop()
+		}
+		stopChannels[lightID] = shared
+	}
+	return shared.ch
 }
 
-// Define a function meant to be edited/rebuilt for timing and debug
-func customRainbow(lightID int, transition float32, sleep float32, stopChannel <-chan struct{}) {
-	for {
-		select {
-		case <-stopChannel:
-			return
-		default:
-			state := rand.IntN(11)
-			switch state {
-			case 0:
-				sendRequestJSON(lightID, []int{255, 0, 0, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 1:
-				sendRequestJSON(lightID, []int{255, 128, 0, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 2:
-				sendRequestJSON(lightID, []int{255, 255, 0, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 3:
-				sendRequestJSON(lightID, []int{128, 255, 0, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 4:
-				sendRequestJSON(lightID, []int{0, 255, 0, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 5:
-				sendRequestJSON(lightID, []int{0, 255, 128, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 6:
-				sendRequestJSON(lightID, []int{0, 255, 255, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 7:
-				sendRequestJSON(lightID, []int{0, 128, 255, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 8:
-				sendRequestJSON(lightID, []int{0, 0, 255, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 9:
-				sendRequestJSON(lightID, []int{128, 0, 255, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 10:
-				sendRequestJSON(lightID, []int{255, 0, 255, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state++
-			case 11:
-				sendRequestJSON(lightID, []int{255, 0, 128, 0}, transition, "None")
-				time.Sleep(time.Duration(sleep) * time.Second)
-				state = 0
-			}
+// stopAllEffects stops the custom effects on every house light, optionally handing them back to the lightboard
+func (m *OSCMap) stopAllEffects(releaseToBoard bool) {
+	stopChannelsMutex.Lock()
+	for lightID, s := range stopChannels {
+		s.stop()
+		stopChannels[lightID] = newEffectStop()
+	}
+	stopChannelsMutex.Unlock()
+
+	if !releaseToBoard {
+		return
+	}
+
+	var released []int
+	for _, f := range m.settings().fixtures.byNumber {
+		if !f.effects && f.dmx == nil {
+			continue
 		}
+		released = append(released, f.number)
+		m.queueLight(f, "all stop", nil, lightStep{rgbw: []int{0, 0, 0, 0}, effect: "Light Board Control"})
 	}
+	m.lights.release(released)
 }
 
-func (m *OSCMap) toggleLight(cueNumber string, cueInteger string) {
-	mc, ok := m.controlMap[cueNumber]
+func (m *OSCMap) toggleLight(cueNumber string, cueInteger string) error {
+	// the cue, its fixtures and its effects are all taken from the same config
+	settings := m.settings()
+	cue := cueNumber
+	mc, ok := settings.controlMap[cue]
 	if !ok {
-		mc, ok = m.controlMap[cueInteger]
+		cue = cueInteger
+		mc, ok = settings.controlMap[cue]
 		if !ok {
 			log.Debugf("No house light interface command for cue[%v]", cueNumber)
-			return
+			return nil
 		}
 	}
 
-	lightIDs := mc.houseLights
-	transitions := mc.transitions
-	effects := mc.effects
-	rgbws := mc.rgbws
-
-	if len(lightIDs) != 0 {
-		// Check length errors
-		if len(lightIDs) != len(transitions) {
-			if len(transitions) != 1 {
-				log.Errorf("Unmatched transitions list length to number of lights in cue[%v]", cueNumber)
-				return
-			}
-		}
-		if len(lightIDs) != len(effects) {
-			if len(effects) != 1 {
-				log.Errorf("Unmatched effects list length to number of lights in cue[%v]", cueNumber)
-				return
-			}
+	// A restore is applied first, so the cue's own houselights take precedence over it
+	houseLights := mc.houseLights
+	if mc.restore != "" {
+		restored, ok := m.lights.restoreCommands(mc.restore)
+		if !ok {
+			log.Warnf("Cannot restore house lights on cue[%v], cue[%v] has not run yet", cueNumber, mc.restore)
 		}
-		if len(lightIDs) != len(rgbws) {
-			if len(rgbws) != 1 {
-				log.Errorf("Unmatched RGBWs list length to number of lights in cue[%v]", cueNumber)
-			}
+		houseLights = mergeLightCommands(restored, houseLights)
+	}
+
+	if len(houseLights) != 0 {
+		if m.homeAssistant == nil && m.dmx == nil {
+			return cueError("Cannot send house light commands for cue[%v], no house light output is configured", cueNumber)
 		}
 
-		for i := 0; i < len(lightIDs); i++ {
-			log.Debugf("Sending light cue to house light %v", lightIDs[i])
+		m.lights.apply(cue, houseLights)
 
-			// Prepare the HTTP request
-			var effect string
-			if len(effects) == 1 {
-				effect = effects[0]
-			} else {
-				effect = effects[i]
+		// Custom effects on the same cue share a start time, and their offsets depend on every light they run on
+		start := time.Now()
+		effectLights := make(map[string][]*fixture)
+		for _, command := range houseLights {
+			if _, ok := settings.customEffects[command.effect]; ok {
+				effectLights[command.effect] = append(effectLights[command.effect], settings.fixtures.byNumber[command.light])
 			}
-			var transition float32
-			if len(transitions) == 1 {
-				transition = transitions[0]
-			} else {
-				transition = transitions[i]
+		}
+		effectOffsets := make(map[int]float32)
+		groupStops := make(map[int]<-chan struct{})
+		for name, lights := range effectLights {
+			customEffect := settings.customEffects[name]
+			lightIDs := make([]int, len(lights))
+			for i, offset := range customEffect.offsets(lights) {
+				lightIDs[i] = lights[i].number
+				effectOffsets[lights[i].number] = offset
 			}
-			var rgbw []int
-			if len(rgbws) == 1 {
-				rgbw = rgbws[0]
-			} else {
-				rgbw = rgbws[i]
+			if customEffect.scope == ScopeGroup {
+				stopChannel := startGroupEffect(lightIDs)
+				for _, lightID := range lightIDs {
+					groupStops[lightID] = stopChannel
+				}
 			}
+		}
+
+		// List lengths and fixtures were checked when the config was read
+		for _, command := range houseLights {
+			log.Debugf("Sending light cue to house light %v", settings.fixtures.byNumber[command.light])
 
-			// Error check RGBW
-			for color := 0; color < 4; color++ {
-				if color < 0 || color > 255 {
-					log.Errorf("Invalid RGBW: %v", color)
+			lightID := command.light
+			f := settings.fixtures.byNumber[lightID]
+			label := fmt.Sprintf("cue[%v]", cueNumber)
+
+			// The transition mode decides the steps, a custom effect is started once they are queued
+			if customEffect, ok := settings.customEffects[command.effect]; ok {
+				stopChannel, grouped := groupStops[lightID]
+				if !grouped {
+					stopEffect(lightID)
+					stopChannel = effectStopChannel(lightID)
 				}
-			}
 
-			lightID := lightIDs[i]
-			sendRequest := func(lightID int, transition float32, effect string, rgbw []int) {
-				// Check effect type - important to set for transition times to or away from light board control
-				if effect == "None" {
-					close(stopChannels[lightID-1])
-					stopChannels[lightID-1] = make(chan struct{})
-
-					sendRequestJSON(lightID,
-						[]int{0, 0, 0, 0},
-						0,
-						"None")
-
-					sendRequestJSON(lightID,
-						rgbw,
-						transition,
-						"None")
-				} else if effect == "Light Board Control" {
-					close(stopChannels[lightID-1])
-					stopChannels[lightID-1] = make(chan struct{})
-
-					sendRequestJSON(lightID,
-						rgbw,
-						transition,
-						"None")
-
-					time.Sleep(time.Duration(transition) * time.Second)
-
-					sendRequestJSON(lightID,
-						rgbw,
-						0,
-						"Light Board Control")
-				} else if effect == "Custom Rainbow" {
-					sendRequestJSON(lightID,
-						[]int{0, 0, 0, 0},
-						0,
-						"None")
-
-					// INFO: Edit these arguments to alter custom rainbow - requires recompiling
-					// lightID, transition, sleep
-					go customRainbow(lightID, transition-0.1, transition+0.1, stopChannels[lightID-1])
-				} else {
-					close(stopChannels[lightID-1])
-					stopChannels[lightID-1] = make(chan struct{})
-
-					sendRequestJSON(lightID,
-						[]int{0, 0, 0, 0},
-						0,
-						"None")
-
-					sendRequestJSON(lightID,
-						rgbw,
-						transition,
-						effect)
+				if steps := command.steps(true); len(steps) != 0 {
+					m.queueLight(f, label, nil, steps...)
 				}
-			}
 
-			go sendRequest(lightID, transition, effect, rgbw)
+				go m.runEffect(f, customEffect, start, effectOffsets[lightID], command.transition, stopChannel)
+			} else {
+				stopEffect(lightID)
+
+				m.queueLight(f, label, nil, command.steps(false)...)
+			}
 		}
 	}
+
+	return nil
 }
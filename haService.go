@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	haServicePattern = regexp.MustCompile(`^([a-z0-9_]+)[./]([a-z0-9_]+)$`)
+	haEntityPattern  = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_]+$`)
+)
+
+// stringList is a list in the config that may also be written as a single string
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// haServiceAction is a Home Assistant service called when a cue fires, e.g. scene.turn_on or switch.toggle
+type haServiceAction struct {
+	domain   string
+	service  string
+	entities []string
+	data     map[string]interface{}
+}
+
+// buildHAServiceActions checks the shape of a cue's services and entities, and parses the templates in their data
+func buildHAServiceActions(actions []confHAServiceAction) ([]haServiceAction, error) {
+	var built []haServiceAction
+	for i, a := range actions {
+		var action haServiceAction
+
+		switch {
+		case a.Scene != "" && a.Service != "":
+			return nil, fmt.Errorf("service action %d has both a service and a scene", i+1)
+		case a.Scene != "":
+			scene := a.Scene
+			if !strings.Contains(scene, ".") {
+				scene = "scene." + scene
+			}
+			action.domain, action.service = "scene", "turn_on"
+			action.entities = []string{scene}
+		case a.Service != "":
+			match := haServicePattern.FindStringSubmatch(a.Service)
+			if match == nil {
+				return nil, fmt.Errorf("invalid service %q, use domain.service e.g. switch.toggle", a.Service)
+			}
+			action.domain, action.service = match[1], match[2]
+		default:
+			return nil, fmt.Errorf("service action %d needs a service or a scene", i+1)
+		}
+
+		action.entities = append(action.entities, a.Entity...)
+		for _, entity := range action.entities {
+			if !haEntityPattern.MatchString(entity) {
+				return nil, fmt.Errorf("invalid entity %q for %s.%s, use domain.name e.g. switch.fog_relay", entity, action.domain, action.service)
+			}
+		}
+
+		if a.Data != nil {
+			data, err := parseBodyTemplates(a.Data)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s has invalid data: %v", action.domain, action.service, err)
+			}
+			action.data = data.(map[string]interface{})
+		}
+		if _, ok := action.data["entity_id"]; ok && len(action.entities) != 0 {
+			return nil, fmt.Errorf("%s.%s has an entity and an entity_id in its data, use one", action.domain, action.service)
+		}
+
+		built = append(built, action)
+	}
+	return built, nil
+}
+
+// callHAServices calls the cue's Home Assistant services in order
+func (m *OSCMap) callHAServices(cueNumber string, cueInteger string) error {
+	controlMap := m.settings().controlMap
+	mc, ok := controlMap[cueNumber]
+	if !ok {
+		mc, ok = controlMap[cueInteger]
+		if !ok {
+			log.Debugf("No home assistant services for cue[%v]", cueNumber)
+			return nil
+		}
+	}
+
+	if len(mc.services) == 0 {
+		return nil
+	}
+	if m.homeAssistant == nil {
+		return cueError("Cannot call home assistant services for cue[%v], home assistant is not configured", cueNumber)
+	}
+
+	var errs []error
+	templateData := newCueTemplateData(cueNumber)
+	for _, a := range mc.services {
+		name := a.domain + "." + a.service
+
+		data := make(map[string]interface{})
+		if a.data != nil {
+			rendered, err := renderBody(a.data, templateData)
+			if err != nil {
+				errs = append(errs, cueError("Failed to build data for %s on cue[%v]: %v", name, cueNumber, err))
+				continue
+			}
+			data = rendered.(map[string]interface{})
+		}
+		if len(a.entities) != 0 {
+			data["entity_id"] = a.entities
+		}
+
+		if err := m.homeAssistant.CallService(a.domain, a.service, data); err != nil {
+			errs = append(errs, cueError("Failed to call %s %v for cue[%v]: %v", name, a.entities, cueNumber, err))
+			continue
+		}
+		log.Infof("Called %s %v for cue[%v]", name, a.entities, cueNumber)
+	}
+	return errors.Join(errs...)
+}
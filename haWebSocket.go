@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	haReconnectMin = 1 * time.Second
+	haReconnectMax = 30 * time.Second
+)
+
+// errHANotConnected is returned when a websocket call is made while the socket is down
+var errHANotConnected = errors.New("home assistant websocket not connected")
+
+// HAEntityState is the last known state of a Home Assistant entity
+type HAEntityState struct {
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
+}
+
+// haMessage covers every field osc-map reads from Home Assistant websocket messages
+type haMessage struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Event *struct {
+		EventType string `json:"event_type"`
+		Data      struct {
+			EntityID string         `json:"entity_id"`
+			NewState *HAEntityState `json:"new_state"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// haWebSocket is a persistent, authenticated connection to the Home Assistant websocket API.
+// It reconnects on its own and keeps the state of every watched entity up to date.
+type haWebSocket struct {
+	url       string
+	token     string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	watch     func(entityID string) bool
+	onState   func(state HAEntityState)
+
+	mutex   sync.Mutex
+	conn    *wsConn
+	nextID  int
+	pending map[int]chan haMessage
+	states  map[string]HAEntityState
+}
+
+func newHAWebSocket(httpURL string, token string, tlsConfig *tls.Config, timeout time.Duration, watch func(string) bool, onState func(HAEntityState)) *haWebSocket {
+	wsURL := httpURL
+	if strings.HasPrefix(wsURL, "https://") {
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	} else {
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+
+	return &haWebSocket{
+		url:       wsURL + "/api/websocket",
+		token:     token,
+		tlsConfig: tlsConfig,
+		timeout:   timeout,
+		watch:     watch,
+		onState:   onState,
+		pending:   make(map[int]chan haMessage),
+		states:    make(map[string]HAEntityState),
+	}
+}
+
+// run keeps the websocket connected for the life of the program
+func (ws *haWebSocket) run() {
+	backoff := haReconnectMin
+	for {
+		err := ws.connect()
+		if err == nil {
+			log.Infof("Connected to home assistant websocket %s", ws.url)
+			backoff = haReconnectMin
+			err = ws.readLoop()
+		}
+		ws.disconnect(err)
+
+		log.Errorf("Home assistant websocket: %v, reconnecting in %v", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > haReconnectMax {
+			backoff = haReconnectMax
+		}
+	}
+}
+
+// connect dials and authenticates, then subscribes to state changes in the background
+func (ws *haWebSocket) connect() error {
+	conn, err := dialWebSocket(ws.url, ws.tlsConfig, ws.timeout)
+	if err != nil {
+		return err
+	}
+
+	var msg haMessage
+	readAuth := func() error {
+		conn.conn.SetReadDeadline(time.Now().Add(ws.timeout))
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &msg)
+	}
+
+	if err := readAuth(); err != nil {
+		conn.Close()
+		return fmt.Errorf("expected auth_required: %v", err)
+	}
+	if msg.Type != "auth_required" {
+		conn.Close()
+		return fmt.Errorf("expected auth_required, got %q", msg.Type)
+	}
+
+	auth, _ := json.Marshal(map[string]string{"type": "auth", "access_token": ws.token})
+	if err := conn.WriteMessage(auth); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if err := readAuth(); err != nil {
+		conn.Close()
+		return err
+	}
+	if msg.Type != "auth_ok" {
+		conn.Close()
+		return fmt.Errorf("authentication failed: %s", msg.Type)
+	}
+	conn.conn.SetReadDeadline(time.Time{})
+
+	ws.mutex.Lock()
+	ws.conn = conn
+	ws.mutex.Unlock()
+
+	// the read loop has to be running before these can be answered
+	go func() {
+		if _, err := ws.send(map[string]interface{}{"type": "subscribe_events", "event_type": "state_changed"}); err != nil {
+			log.Errorf("Failed to subscribe to home assistant state changes: %v", err)
+			return
+		}
+
+		result, err := ws.send(map[string]interface{}{"type": "get_states"})
+		if err != nil {
+			log.Errorf("Failed to get home assistant states: %v", err)
+			return
+		}
+		var states []HAEntityState
+		if err := json.Unmarshal(result, &states); err != nil {
+			log.Errorf("Failed to read home assistant states: %v", err)
+			return
+		}
+		for _, state := range states {
+			ws.setState(state)
+		}
+	}()
+
+	return nil
+}
+
+// disconnect drops the connection and fails every call still waiting on it
+func (ws *haWebSocket) disconnect(err error) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.conn != nil {
+		ws.conn.Close()
+		ws.conn = nil
+	}
+	for id, ch := range ws.pending {
+		close(ch)
+		delete(ws.pending, id)
+	}
+}
+
+func (ws *haWebSocket) readLoop() error {
+	for {
+		data, err := ws.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var msg haMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Errorf("Unreadable home assistant message: %v", err)
+			continue
+		}
+
+		switch msg.Type {
+		case "result":
+			ws.mutex.Lock()
+			ch, ok := ws.pending[msg.ID]
+			delete(ws.pending, msg.ID)
+			ws.mutex.Unlock()
+			if ok {
+				ch <- msg
+			}
+		case "event":
+			if msg.Event != nil && msg.Event.Data.NewState != nil {
+				ws.setState(*msg.Event.Data.NewState)
+			}
+		}
+	}
+}
+
+func (ws *haWebSocket) setState(state HAEntityState) {
+	if !ws.watch(state.EntityID) {
+		return
+	}
+
+	ws.mutex.Lock()
+	ws.states[state.EntityID] = state
+	ws.mutex.Unlock()
+
+	log.Debugf("Home assistant state %s: %s %v", state.EntityID, state.State, state.Attributes)
+
+	if ws.onState != nil {
+		ws.onState(state)
+	}
+}
+
+// connected reports whether commands can currently be sent over the websocket
+func (ws *haWebSocket) connected() bool {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	return ws.conn != nil
+}
+
+// send assigns the message an id, sends it and waits for its result
+func (ws *haWebSocket) send(message map[string]interface{}) (json.RawMessage, error) {
+	ws.mutex.Lock()
+	if ws.conn == nil {
+		ws.mutex.Unlock()
+		return nil, errHANotConnected
+	}
+	ws.nextID++
+	id := ws.nextID
+	ch := make(chan haMessage, 1)
+	ws.pending[id] = ch
+	conn := ws.conn
+	ws.mutex.Unlock()
+
+	message["id"] = id
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.WriteMessage(data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHomeAssistantUnavailable, err)
+	}
+
+	timer := time.NewTimer(ws.timeout)
+	defer timer.Stop()
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("%w: connection lost", ErrHomeAssistantUnavailable)
+		}
+		if !msg.Success {
+			e := &HomeAssistantError{Service: fmt.Sprint(message["type"])}
+			if msg.Error != nil {
+				e.Body = msg.Error.Code + ": " + msg.Error.Message
+			}
+			return nil, e
+		}
+		return msg.Result, nil
+	case <-timer.C:
+		ws.mutex.Lock()
+		delete(ws.pending, id)
+		ws.mutex.Unlock()
+		return nil, fmt.Errorf("%w: no response to %v", ErrHomeAssistantUnavailable, message["type"])
+	}
+}
+
+// callService sends a call_service message. The data is passed through as the service data.
+func (ws *haWebSocket) callService(domain string, service string, data interface{}) error {
+	_, err := ws.send(map[string]interface{}{
+		"type":         "call_service",
+		"domain":       domain,
+		"service":      service,
+		"service_data": data,
+	})
+	if err, ok := err.(*HomeAssistantError); ok {
+		err.Service = domain + "/" + service
+	}
+	return err
+}
+
+// state returns the last known state of an entity
+func (ws *haWebSocket) state(entityID string) (HAEntityState, bool) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	state, ok := ws.states[entityID]
+	return state, ok
+}
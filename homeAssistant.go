@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHomeAssistantTokenEnv = "HAKEY"
+	DefaultHomeAssistantTimeout  = 5 // seconds
+)
+
+// ErrHomeAssistantUnavailable is returned when Home Assistant cannot be reached at all
+var ErrHomeAssistantUnavailable = errors.New("home assistant unavailable")
+
+// HomeAssistantError is returned when Home Assistant answers a request with an error status
+type HomeAssistantError struct {
+	Service    string
+	StatusCode int
+	Body       string
+}
+
+func (e *HomeAssistantError) Error() string {
+	return fmt.Sprintf("home assistant %s returned %d: %s", e.Service, e.StatusCode, e.Body)
+}
+
+// HomeAssistant is a client for the Home Assistant API shared by every house light command. Service
+// calls go over the websocket when it is enabled and connected, and over REST otherwise.
+type HomeAssistant struct {
+	url    string
+	token  string
+	client *http.Client
+	ws     *haWebSocket
+
+	// house light requests are queued per entity and sent at most max-rate times a second
+	lights *lightQueue
+}
+
+// newHomeAssistant creates the client described by outputs.home-assistant. When the websocket is
+// enabled, the state of every entity that watch accepts is tracked and passed to onState as it changes.
+func newHomeAssistant(c confHomeAssistant, watch func(entityID string) bool, onState func(HAEntityState)) (*HomeAssistant, error) {
+	url := c.URL
+	if url == "" {
+		url = fmt.Sprintf("%s:%d", DefaultHomeAssistantHTTP, DefaultHomeAssistantPort)
+	}
+
+	token, err := c.readToken()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHomeAssistantTimeout
+	}
+
+	maxRate := c.MaxRate
+	if maxRate <= 0 {
+		maxRate = DefaultHomeAssistantMaxRate
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read home assistant CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	ha := &HomeAssistant{
+		url:   strings.TrimSuffix(url, "/"),
+		token: token,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(timeout * float32(time.Second)),
+		},
+	}
+	ha.lights = newLightQueue(time.Duration(float32(time.Second)/maxRate), ha.sendLightStep)
+
+	if c.WebSocket {
+		ha.ws = newHAWebSocket(ha.url, token, tlsConfig, ha.client.Timeout, watch, onState)
+		go ha.ws.run()
+	}
+
+	return ha, nil
+}
+
+// readToken reads the long lived access token from token-file if set, otherwise from the token-env variable
+func (c confHomeAssistant) readToken() (string, error) {
+	if c.TokenFile != "" {
+		token, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read home assistant token file: %v", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+
+	env := c.TokenEnv
+	if env == "" {
+		env = DefaultHomeAssistantTokenEnv
+	}
+	token := os.Getenv(env)
+	if token == "" {
+		return "", fmt.Errorf("home assistant token variable %s is not set", env)
+	}
+	return token, nil
+}
+
+// CallService calls a Home Assistant service, e.g. light/turn_on, with the data encoded as JSON
+func (ha *HomeAssistant) CallService(domain string, service string, data interface{}) error {
+	if ha.ws != nil {
+		err := ha.ws.callService(domain, service, data)
+		if !errors.Is(err, errHANotConnected) {
+			return err
+		}
+	}
+
+	return ha.callServiceREST(domain, service, data)
+}
+
+// callServiceREST calls a service through the REST API
+func (ha *HomeAssistant) callServiceREST(domain string, service string, data interface{}) error {
+	name := domain + "/" + service
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("unable to create json data for %s: %v", name, err)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", ha.token))
+	header.Set("Content-Type", "application/json")
+
+	_, err = doHTTPRequest(context.Background(), ha.client, http.MethodPost, fmt.Sprintf("%s/api/services/%s", ha.url, name), header, jsonData)
+
+	var respErr *httpResponseError
+	if errors.As(err, &respErr) {
+		return &HomeAssistantError{
+			Service:    name,
+			StatusCode: respErr.StatusCode,
+			Body:       respErr.Body,
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHomeAssistantUnavailable, err)
+	}
+
+	return nil
+}
+
+// State returns the last known state of a house light. States are only tracked over the websocket.
+func (ha *HomeAssistant) State(entityID string) (HAEntityState, bool) {
+	if ha.ws == nil {
+		return HAEntityState{}, false
+	}
+	return ha.ws.state(entityID)
+}
+
+// Connected reports whether the websocket is enabled and connected
+func (ha *HomeAssistant) Connected() bool {
+	return ha.ws != nil && ha.ws.connected()
+}
+
+// turnOnLight sets a house light's color, transition and effect in the fixture's native color mode
+func (ha *HomeAssistant) turnOnLight(f *fixture, rgbw []int, transition float32, effect string) error {
+	data := f.lightRequest(rgbw, transition, effect)
+
+	return ha.CallService("light", "turn_on", &data)
+}
+
+// sendLightStep sends a queued step to a house light. A brightness-only step leaves the color to Home Assistant.
+func (ha *HomeAssistant) sendLightStep(f *fixture, step lightStep) error {
+	if step.brightnessOnly {
+		data := f.brightnessRequest(step.rgbw, step.transition, step.effect)
+		return ha.CallService("light", "turn_on", &data)
+	}
+
+	return ha.turnOnLight(f, step.rgbw, step.transition, step.effect)
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// listenForHotkey watches the terminal for the hotkey name followed by enter, as there is no
+// portable way to register a system wide hotkey. It blocks until stdin is closed.
+func listenForHotkey(hk hotkey, action func()) error {
+	log.Infof("Type %s and press enter at any time to trigger an all stop", hk.name)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if strings.EqualFold(strings.TrimSpace(scanner.Text()), hk.name) {
+			action()
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,92 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	user32               = syscall.NewLazyDLL("user32.dll")
+	procRegisterHotKey   = user32.NewProc("RegisterHotKey")
+	procUnregisterHotKey = user32.NewProc("UnregisterHotKey")
+	procGetMessage       = user32.NewProc("GetMessageW")
+	procMapVirtualKey    = user32.NewProc("MapVirtualKeyW")
+)
+
+const (
+	modAlt       = 0x0001
+	modControl   = 0x0002
+	modShift     = 0x0004
+	modNoRepeat  = 0x4000
+	wmHotkey     = 0x0312
+	mapvkVscToVk = 1
+	hotkeyID     = 1
+)
+
+// winMsg mirrors the Win32 MSG struct
+type winMsg struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	x, y    int32
+}
+
+// listenForHotkey registers a system wide hotkey so it works no matter which window has focus.
+// It blocks for the life of the program.
+func listenForHotkey(hk hotkey, action func()) error {
+	// hotkey messages are delivered to the thread that registered them
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// KeyboardMap holds scan codes, except for keys offset by 0xFFF which are already virtual keys
+	var vk uintptr
+	if hk.key > 0xFFF {
+		vk = uintptr(hk.key - 0xFFF)
+	} else {
+		vk, _, _ = procMapVirtualKey.Call(uintptr(hk.key), mapvkVscToVk)
+		if vk == 0 {
+			return fmt.Errorf("no virtual key for scan code %d", hk.key)
+		}
+	}
+
+	var modifiers uintptr = modNoRepeat
+	if hk.ctrl {
+		modifiers |= modControl
+	}
+	if hk.alt {
+		modifiers |= modAlt
+	}
+	if hk.shift {
+		modifiers |= modShift
+	}
+
+	ok, _, err := procRegisterHotKey.Call(0, hotkeyID, modifiers, vk)
+	if ok == 0 {
+		return err
+	}
+	defer procUnregisterHotKey.Call(0, hotkeyID)
+
+	log.Infof("Press %s at any time to trigger an all stop", hk.name)
+
+	var msg winMsg
+	for {
+		ret, _, err := procGetMessage.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+		if int32(ret) == -1 {
+			return err
+		}
+		if ret == 0 {
+			return nil
+		}
+		if msg.message == wmHotkey && msg.wParam == hotkeyID {
+			action()
+		}
+	}
+}
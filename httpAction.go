@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultHTTPTimeout = 5 // seconds
+	httpRetryDelay     = 500 * time.Millisecond
+)
+
+// httpResponseError is returned when a server answers a request with an error status
+type httpResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpResponseError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// doHTTPRequest sends a request and reads the response. Any status from 300 up is returned as an httpResponseError.
+func doHTTPRequest(ctx context.Context, client *http.Client, method string, target string, header http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return nil, &httpResponseError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+	}
+	return respBody, err
+}
+
+// httpBody is a request body from the config. A string is sent as it is, anything else is sent as JSON.
+// Every string in it may be a template.
+type httpBody struct {
+	value interface{}
+}
+
+func (b *httpBody) UnmarshalYAML(value *yaml.Node) error {
+	return value.Decode(&b.value)
+}
+
+// httpAction is a request sent when a cue fires, e.g. to a webhook, Companion or a projector's web API
+type httpAction struct {
+	method  string
+	url     *template.Template
+	header  map[string]*template.Template
+	body    interface{}
+	json    bool
+	timeout time.Duration
+	retries int
+}
+
+// buildHTTPActions checks a cue's http list and parses its templates
+func buildHTTPActions(actions []confHTTPAction) ([]httpAction, error) {
+	var built []httpAction
+	for i, a := range actions {
+		if a.URL == "" {
+			return nil, fmt.Errorf("http action %d needs a url", i+1)
+		}
+		if !strings.Contains(a.URL, "{{") {
+			u, err := url.Parse(a.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("invalid url %q, use http:// or https://", a.URL)
+			}
+		}
+		urlTemplate, err := parseCueTemplate("url", a.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url %q: %v", a.URL, err)
+		}
+		if a.Retries < 0 {
+			return nil, fmt.Errorf("%s has negative retries", a.URL)
+		}
+
+		action := httpAction{
+			method:  strings.ToUpper(a.Method),
+			url:     urlTemplate,
+			header:  make(map[string]*template.Template),
+			retries: a.Retries,
+		}
+
+		if a.Body.value != nil {
+			_, text := a.Body.value.(string)
+			action.json = !text
+			action.body, err = parseBodyTemplates(a.Body.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s has an invalid body: %v", a.URL, err)
+			}
+		}
+
+		switch action.method {
+		case "":
+			action.method = http.MethodGet
+			if action.body != nil {
+				action.method = http.MethodPost
+			}
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			return nil, fmt.Errorf("%s has an unknown method %q, use GET, HEAD, POST, PUT, PATCH, DELETE or OPTIONS", a.URL, a.Method)
+		}
+
+		for name, value := range a.Headers {
+			action.header[name], err = parseCueTemplate(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s has an invalid %s header: %v", a.URL, name, err)
+			}
+		}
+
+		timeout := a.Timeout
+		if timeout <= 0 {
+			timeout = DefaultHTTPTimeout
+		}
+		action.timeout = time.Duration(timeout * float32(time.Second))
+
+		built = append(built, action)
+	}
+	return built, nil
+}
+
+// parseBodyTemplates replaces every string in a body with its parsed template
+func parseBodyTemplates(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return parseCueTemplate("body", v)
+	case map[string]interface{}:
+		parsed := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			p, err := parseBodyTemplates(item)
+			if err != nil {
+				return nil, err
+			}
+			parsed[key] = p
+		}
+		return parsed, nil
+	case []interface{}:
+		parsed := make([]interface{}, len(v))
+		for i, item := range v {
+			p, err := parseBodyTemplates(item)
+			if err != nil {
+				return nil, err
+			}
+			parsed[i] = p
+		}
+		return parsed, nil
+	}
+	return value, nil
+}
+
+// renderBody executes the templates in a body for a cue
+func renderBody(value interface{}, data cueTemplateData) (interface{}, error) {
+	switch v := value.(type) {
+	case *template.Template:
+		var text strings.Builder
+		if err := v.Execute(&text, data); err != nil {
+			return nil, err
+		}
+		return text.String(), nil
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := renderBody(item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderBody(item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+// request builds the url, headers and body of the action for a cue
+func (a httpAction) request(data cueTemplateData) (string, http.Header, []byte, error) {
+	var u strings.Builder
+	if err := a.url.Execute(&u, data); err != nil {
+		return "", nil, nil, fmt.Errorf("url: %v", err)
+	}
+
+	header := make(http.Header)
+	names := make([]string, 0, len(a.header))
+	for name := range a.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var value strings.Builder
+		if err := a.header[name].Execute(&value, data); err != nil {
+			return "", nil, nil, fmt.Errorf("%s header: %v", name, err)
+		}
+		header.Set(name, value.String())
+	}
+
+	if a.body == nil {
+		return u.String(), header, nil, nil
+	}
+
+	body, err := renderBody(a.body, data)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("body: %v", err)
+	}
+	if !a.json {
+		return u.String(), header, []byte(body.(string)), nil
+	}
+
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("body: %v", err)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	return u.String(), header, jsonData, nil
+}
+
+// retryable reports whether a failed request may succeed if it is sent again
+func retryable(err error) bool {
+	var respErr *httpResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= 500 || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// webhookClient is shared by every http action, each request has its own timeout
+var webhookClient = &http.Client{}
+
+// callWebhooks sends the cue's http requests in order, retrying those that fail
+func (m *OSCMap) callWebhooks(cueNumber string, cueInteger string) error {
+	controlMap := m.settings().controlMap
+	mc, ok := controlMap[cueNumber]
+	if !ok {
+		mc, ok = controlMap[cueInteger]
+		if !ok {
+			log.Debugf("No http actions for cue[%v]", cueNumber)
+			return nil
+		}
+	}
+
+	var errs []error
+	data := newCueTemplateData(cueNumber)
+	for _, a := range mc.http {
+		if err := a.send(cueNumber, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a httpAction) send(cueNumber string, data cueTemplateData) error {
+	u, header, body, err := a.request(data)
+	if err != nil {
+		return cueError("Failed to build http request for cue[%v]: %v", cueNumber, err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+		resp, err := doHTTPRequest(ctx, webhookClient, a.method, u, header, body)
+		cancel()
+
+		if err == nil {
+			log.Infof("Sent %s %s for cue[%v]", a.method, u, cueNumber)
+			log.Debugf("Response from %s: %s", u, resp)
+			return nil
+		}
+		if attempt >= a.retries || !retryable(err) {
+			return cueError("Failed to send %s %s for cue[%v]: %v", a.method, u, cueNumber, err)
+		}
+
+		log.Warnf("Failed to send %s %s for cue[%v], retrying: %v", a.method, u, cueNumber, err)
+		time.Sleep(httpRetryDelay * time.Duration(attempt+1))
+	}
+}
@@ -1,35 +1,80 @@
 package main
 
-import log "github.com/sirupsen/logrus"
+import (
+	"errors"
+	"strings"
+	"time"
 
-// sendKeyboardCommand simulates a keyboard keypress. Useful for soundboard programs
-func (m *OSCMap) sendKeyboardCommand(cueNumber string, cueInteger string) {
-	if m.keyBonding == nil {
-		log.Errorf("keybonding is nil")
-		return
+	log "github.com/sirupsen/logrus"
+)
+
+// sendKeyboardCommand simulates keyboard keypresses. Useful for soundboard programs
+func (m *OSCMap) sendKeyboardCommand(cueNumber string, cueInteger string) error {
+	if m.keyboard == nil {
+		return cueError("Cannot send keyboard for cue[%v], keyboard-commands is off", cueNumber)
 	}
 
-	cueMap, ok := m.controlMap[cueNumber]
+	controlMap := m.settings().controlMap
+	cueMap, ok := controlMap[cueNumber]
 	if !ok {
-		cueMap, ok = m.controlMap[cueInteger]
+		cueMap, ok = controlMap[cueInteger]
 		if !ok {
 			log.Debugf("No virtual keyboard command for cue[%v]", cueNumber)
-			return
+			return nil
 		}
 	}
 
-	if cueMap.keyboardKey == -1 {
+	if len(cueMap.keyboard) == 0 {
 		log.Infof("No keyboard key specified for cue[%v]", cueNumber)
-		return
+		return nil
 	}
 
-	m.keyBonding.SetKeys(cueMap.keyboardKey)
+	// one keyboard is shared by every cue, so sequences from cues close together are not interleaved
+	m.keyboardMutex.Lock()
+	defer m.keyboardMutex.Unlock()
 
-	log.Debugf("Sending keyboard: %v", cueMap.keyboardKey)
+	// focus is checked before the first key and after every wait, when the operator may have clicked elsewhere
+	checkWindow := true
+	var errs []error
+	for i, step := range cueMap.keyboard {
+		if step.wait > 0 {
+			time.Sleep(step.wait)
+			checkWindow = true
+			continue
+		}
+		if i > 0 {
+			time.Sleep(DefaultKeyDelay)
+		}
 
-	// Press the selected keys
-	err := m.keyBonding.Launching()
+		if checkWindow {
+			if err := m.keyboardTargetFocused(cueNumber); err != nil {
+				return err
+			}
+		}
+		checkWindow = false
+
+		log.Debugf("Sending keyboard: %s", step.key.name)
+
+		if err := m.keyboard.send(step.key); err != nil {
+			errs = append(errs, cueError("failed to send key %s: %v", step.key.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keyboardTargetFocused checks that the window keys are meant for has focus, so that a cue is never typed into
+// a browser or the lightboard's remote. Without a keyboard-window every window is allowed.
+func (m *OSCMap) keyboardTargetFocused(cueNumber string) error {
+	if m.keyboardWindow == "" {
+		return nil
+	}
+
+	title, err := activeWindowTitle()
 	if err != nil {
-		log.Errorf("failed to launch key: %X", cueMap.keyboardKey)
+		return cueError("Not sending keyboard for cue[%v], cannot check for the %q window: %v", cueNumber, m.keyboardWindow, err)
+	}
+	if !strings.Contains(strings.ToLower(title), strings.ToLower(m.keyboardWindow)) {
+		return cueError("Not sending keyboard for cue[%v], %q has focus instead of %q", cueNumber, title, m.keyboardWindow)
 	}
+	return nil
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micmonay/keybd_event"
+	log "github.com/sirupsen/logrus"
+)
+
+// keyboardBackend presses keys for keyboard cues
+type keyboardBackend interface {
+	// send presses and releases a key with its modifiers
+	send(key hotkey) error
+}
+
+// newKeyboardBackend creates the backend named by outputs.keyboard-backend
+func newKeyboardBackend(name string) (keyboardBackend, error) {
+	switch strings.ToLower(name) {
+	case "", "system":
+		return newSystemKeyboard(), nil
+	case "record":
+		return &recordingKeyboard{log: true}, nil
+	}
+	return nil, fmt.Errorf("unknown keyboard backend %q, use system or record", name)
+}
+
+// systemKeyboard sends keys to the operating system with keybd_event, through a uinput device on Linux.
+// The device is set up in the background, and keys sent before it is ready wait for it.
+type systemKeyboard struct {
+	ready chan struct{}
+	err   error
+	kb    keybd_event.KeyBonding
+}
+
+func newSystemKeyboard() *systemKeyboard {
+	k := &systemKeyboard{ready: make(chan struct{})}
+
+	go func() {
+		defer close(k.ready)
+
+		kb, err := keybd_event.NewKeyBonding()
+		if err != nil {
+			k.err = err
+			log.Errorf("Failed to create key bonding: %v", err)
+			return
+		}
+
+		// For linux, it is very important to wait 2 seconds for the new device to be picked up
+		if runtime.GOOS == "linux" {
+			time.Sleep(2 * time.Second)
+		}
+
+		k.kb = kb
+		log.Info("Keyboard ready")
+	}()
+
+	return k
+}
+
+func (k *systemKeyboard) send(key hotkey) error {
+	<-k.ready
+	if k.err != nil {
+		return fmt.Errorf("no keyboard: %v", k.err)
+	}
+
+	k.kb.Clear()
+	k.kb.SetKeys(key.key)
+	k.kb.HasCTRL(key.ctrl)
+	k.kb.HasALT(key.alt)
+	k.kb.HasSHIFT(key.shift)
+
+	// Press the selected keys
+	if err := k.kb.Press(); err != nil {
+		return err
+	}
+	return k.kb.Release()
+}
+
+// recordingKeyboard keeps the keys it is sent instead of pressing them, for rehearsing without the cue
+// software and for checking keyboard cues without a display
+type recordingKeyboard struct {
+	log bool
+
+	mutex sync.Mutex
+	keys  []hotkey
+}
+
+func (k *recordingKeyboard) send(key hotkey) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys = append(k.keys, key)
+	if k.log {
+		log.Infof("Recorded keyboard: %s", key.name)
+	}
+	return nil
+}
+
+// pressed returns the names of the keys sent so far, oldest first
+func (k *recordingKeyboard) pressed() []string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	names := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		names[i] = key.name
+	}
+	return names
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// DefaultKeyDelay is the pause between the keys of a sequence, so cue software sees each one
+const DefaultKeyDelay = 20 * time.Millisecond
+
+// keyboardSpec is a keyboard action as written in the config: a single key such as "ctrl+shift+s",
+// or a list of keys, waits and typed text, e.g. ["esc", {wait: 200ms}, {type: "Act 2"}, "ent"].
+// Like colorSpec, it is only checked by steps so that a mistake is reported with the cue it is in.
+type keyboardSpec struct {
+	items []keyboardItem
+}
+
+type keyboardItem struct {
+	Key  string `yaml:"key"`
+	Wait string `yaml:"wait"`
+	Type string `yaml:"type"`
+}
+
+func (k *keyboardSpec) UnmarshalYAML(value *yaml.Node) error {
+	nodes := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		nodes = value.Content
+	}
+
+	for _, node := range nodes {
+		var item keyboardItem
+		var err error
+		if node.Kind == yaml.ScalarNode {
+			err = node.Decode(&item.Key)
+		} else {
+			err = node.Decode(&item)
+		}
+		if err != nil {
+			return err
+		}
+		k.items = append(k.items, item)
+	}
+	return nil
+}
+
+func (k keyboardSpec) String() string {
+	var parts []string
+	for _, item := range k.items {
+		switch {
+		case item.Wait != "":
+			parts = append(parts, "wait "+item.Wait)
+		case item.Type != "":
+			parts = append(parts, "type "+strconv.Quote(item.Type))
+		default:
+			parts = append(parts, item.Key)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// keyStep is a single key with its modifiers, or a pause when wait is set
+type keyStep struct {
+	key  hotkey
+	wait time.Duration
+}
+
+// steps validates the keyboard action and expands it into the keys to press
+func (k keyboardSpec) steps() ([]keyStep, error) {
+	var steps []keyStep
+	for _, item := range k.items {
+		switch {
+		case item.Wait != "":
+			wait, err := parseKeyWait(item.Wait)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, keyStep{wait: wait})
+		case item.Type != "":
+			typed, err := typeText(item.Type)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, typed...)
+		case item.Key != "":
+			hk, err := parseHotkey(item.Key)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, keyStep{key: hk})
+		default:
+			return nil, fmt.Errorf("keyboard entries need a key, wait or type")
+		}
+	}
+	return steps, nil
+}
+
+// parseKeyWait reads a wait such as "200ms" or "1.5s". A bare number is in milliseconds. The wait must be
+// more than zero, as a step without a wait is taken to be a key press.
+func parseKeyWait(s string) (time.Duration, error) {
+	value := s
+	if ms, err := strconv.ParseFloat(s, 64); err == nil {
+		value = fmt.Sprint(ms, "ms")
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait <= 0 {
+		return 0, fmt.Errorf("invalid wait %q, use a duration above zero, e.g. 200ms", s)
+	}
+	return wait, nil
+}
+
+// typeText turns text into key presses, holding shift for capital letters and the symbols above the number and punctuation keys
+func typeText(text string) ([]keyStep, error) {
+	var steps []keyStep
+	for _, r := range text {
+		var name string
+		shift := false
+		switch {
+		case r == ' ':
+			name = "space"
+		case r == '\n':
+			name = "ent"
+		case r == '\t':
+			name = "tab"
+		case unicode.IsUpper(r):
+			name = string(unicode.ToLower(r))
+			shift = true
+		case shiftedKeys[r] != "":
+			name = shiftedKeys[r]
+			shift = true
+		default:
+			name = string(r)
+		}
+
+		key, ok := lookupKey(name)
+		if !ok {
+			return nil, fmt.Errorf("cannot type %q in %q", r, text)
+		}
+		steps = append(steps, keyStep{key: hotkey{name: string(r), key: key, shift: shift}})
+	}
+	return steps, nil
+}
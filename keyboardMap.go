@@ -1,84 +1,155 @@
 package main
 
-import "github.com/micmonay/keybd_event"
+import (
+	"strings"
 
+	"github.com/micmonay/keybd_event"
+)
+
+// KeyboardMap holds the keys that can be pressed on every platform, by lower case name.
+// Use lookupKey rather than reading it directly, as it also checks the platform's own keys.
 var KeyboardMap = map[string]int{
-	"a":     keybd_event.VK_A,
-	"b":     keybd_event.VK_B,
-	"c":     keybd_event.VK_C,
-	"d":     keybd_event.VK_D,
-	"e":     keybd_event.VK_E,
-	"f":     keybd_event.VK_F,
-	"g":     keybd_event.VK_G,
-	"h":     keybd_event.VK_H,
-	"i":     keybd_event.VK_I,
-	"j":     keybd_event.VK_J,
-	"k":     keybd_event.VK_K,
-	"l":     keybd_event.VK_L,
-	"m":     keybd_event.VK_M,
-	"n":     keybd_event.VK_N,
-	"o":     keybd_event.VK_O,
-	"p":     keybd_event.VK_P,
-	"q":     keybd_event.VK_Q,
-	"r":     keybd_event.VK_R,
-	"s":     keybd_event.VK_S,
-	"t":     keybd_event.VK_T,
-	"u":     keybd_event.VK_U,
-	"v":     keybd_event.VK_V,
-	"w":     keybd_event.VK_W,
-	"x":     keybd_event.VK_X,
-	"y":     keybd_event.VK_Y,
-	"z":     keybd_event.VK_Z,
-	"A":     keybd_event.VK_A,
-	"B":     keybd_event.VK_B,
-	"C":     keybd_event.VK_C,
-	"D":     keybd_event.VK_D,
-	"E":     keybd_event.VK_E,
-	"F":     keybd_event.VK_F,
-	"G":     keybd_event.VK_G,
-	"H":     keybd_event.VK_H,
-	"I":     keybd_event.VK_I,
-	"J":     keybd_event.VK_J,
-	"K":     keybd_event.VK_K,
-	"L":     keybd_event.VK_L,
-	"M":     keybd_event.VK_M,
-	"N":     keybd_event.VK_N,
-	"O":     keybd_event.VK_O,
-	"P":     keybd_event.VK_P,
-	"Q":     keybd_event.VK_Q,
-	"R":     keybd_event.VK_R,
-	"S":     keybd_event.VK_S,
-	"T":     keybd_event.VK_T,
-	"U":     keybd_event.VK_U,
-	"V":     keybd_event.VK_V,
-	"W":     keybd_event.VK_W,
-	"X":     keybd_event.VK_X,
-	"Y":     keybd_event.VK_Y,
-	"Z":     keybd_event.VK_Z,
-	"0":     keybd_event.VK_0,
-	"1":     keybd_event.VK_1,
-	"2":     keybd_event.VK_2,
-	"3":     keybd_event.VK_3,
-	"4":     keybd_event.VK_4,
-	"5":     keybd_event.VK_5,
-	"6":     keybd_event.VK_6,
-	"7":     keybd_event.VK_7,
-	"8":     keybd_event.VK_8,
-	"9":     keybd_event.VK_9,
-	"bs":    keybd_event.VK_BACKSPACE,
-	"ent":   keybd_event.VK_ENTER,
-	"esc":   keybd_event.VK_ESC,
-	"BS":    keybd_event.VK_BACKSPACE,
-	"ENT":   keybd_event.VK_ENTER,
-	"ESC":   keybd_event.VK_ESC,
-	"space": keybd_event.VK_SPACE,
-	"SPACE": keybd_event.VK_SPACE,
-	"up":    keybd_event.VK_UP,
-	"right": keybd_event.VK_RIGHT,
-	"left":  keybd_event.VK_LEFT,
-	"down":  keybd_event.VK_DOWN,
-	"UP":    keybd_event.VK_UP,
-	"RIGHT": keybd_event.VK_RIGHT,
-	"LEFT":  keybd_event.VK_LEFT,
-	"DOWN":  keybd_event.VK_DOWN,
+	"a": keybd_event.VK_A,
+	"b": keybd_event.VK_B,
+	"c": keybd_event.VK_C,
+	"d": keybd_event.VK_D,
+	"e": keybd_event.VK_E,
+	"f": keybd_event.VK_F,
+	"g": keybd_event.VK_G,
+	"h": keybd_event.VK_H,
+	"i": keybd_event.VK_I,
+	"j": keybd_event.VK_J,
+	"k": keybd_event.VK_K,
+	"l": keybd_event.VK_L,
+	"m": keybd_event.VK_M,
+	"n": keybd_event.VK_N,
+	"o": keybd_event.VK_O,
+	"p": keybd_event.VK_P,
+	"q": keybd_event.VK_Q,
+	"r": keybd_event.VK_R,
+	"s": keybd_event.VK_S,
+	"t": keybd_event.VK_T,
+	"u": keybd_event.VK_U,
+	"v": keybd_event.VK_V,
+	"w": keybd_event.VK_W,
+	"x": keybd_event.VK_X,
+	"y": keybd_event.VK_Y,
+	"z": keybd_event.VK_Z,
+	"0": keybd_event.VK_0,
+	"1": keybd_event.VK_1,
+	"2": keybd_event.VK_2,
+	"3": keybd_event.VK_3,
+	"4": keybd_event.VK_4,
+	"5": keybd_event.VK_5,
+	"6": keybd_event.VK_6,
+	"7": keybd_event.VK_7,
+	"8": keybd_event.VK_8,
+	"9": keybd_event.VK_9,
+
+	"f1":  keybd_event.VK_F1,
+	"f2":  keybd_event.VK_F2,
+	"f3":  keybd_event.VK_F3,
+	"f4":  keybd_event.VK_F4,
+	"f5":  keybd_event.VK_F5,
+	"f6":  keybd_event.VK_F6,
+	"f7":  keybd_event.VK_F7,
+	"f8":  keybd_event.VK_F8,
+	"f9":  keybd_event.VK_F9,
+	"f10": keybd_event.VK_F10,
+	"f11": keybd_event.VK_F11,
+	"f12": keybd_event.VK_F12,
+	"f13": keybd_event.VK_F13,
+	"f14": keybd_event.VK_F14,
+	"f15": keybd_event.VK_F15,
+	"f16": keybd_event.VK_F16,
+	"f17": keybd_event.VK_F17,
+	"f18": keybd_event.VK_F18,
+	"f19": keybd_event.VK_F19,
+	"f20": keybd_event.VK_F20,
+	"f21": keybd_event.VK_F21,
+	"f22": keybd_event.VK_F22,
+	"f23": keybd_event.VK_F23,
+	"f24": keybd_event.VK_F24,
+
+	"bs":         keybd_event.VK_BACKSPACE,
+	"backspace":  keybd_event.VK_BACKSPACE,
+	"ent":        keybd_event.VK_ENTER,
+	"enter":      keybd_event.VK_ENTER,
+	"return":     keybd_event.VK_ENTER,
+	"esc":        keybd_event.VK_ESC,
+	"escape":     keybd_event.VK_ESC,
+	"space":      keybd_event.VK_SPACE,
+	"tab":        keybd_event.VK_TAB,
+	"up":         keybd_event.VK_UP,
+	"down":       keybd_event.VK_DOWN,
+	"left":       keybd_event.VK_LEFT,
+	"right":      keybd_event.VK_RIGHT,
+	"home":       keybd_event.VK_HOME,
+	"end":        keybd_event.VK_END,
+	"pageup":     keybd_event.VK_PAGEUP,
+	"pgup":       keybd_event.VK_PAGEUP,
+	"pagedown":   keybd_event.VK_PAGEDOWN,
+	"pgdn":       keybd_event.VK_PAGEDOWN,
+	"insert":     keybd_event.VK_INSERT,
+	"ins":        keybd_event.VK_INSERT,
+	"delete":     keybd_event.VK_DELETE,
+	"del":        keybd_event.VK_DELETE,
+	"capslock":   keybd_event.VK_CAPSLOCK,
+	"numlock":    keybd_event.VK_NUMLOCK,
+	"scrolllock": keybd_event.VK_SCROLLLOCK,
+	"pause":      keybd_event.VK_PAUSE,
+
+	"num0": keybd_event.VK_KP0,
+	"num1": keybd_event.VK_KP1,
+	"num2": keybd_event.VK_KP2,
+	"num3": keybd_event.VK_KP3,
+	"num4": keybd_event.VK_KP4,
+	"num5": keybd_event.VK_KP5,
+	"num6": keybd_event.VK_KP6,
+	"num7": keybd_event.VK_KP7,
+	"num8": keybd_event.VK_KP8,
+	"num9": keybd_event.VK_KP9,
+	"num*": keybd_event.VK_KPASTERISK,
+	"num-": keybd_event.VK_KPMINUS,
+	"num+": keybd_event.VK_KPPLUS,
+	"num.": keybd_event.VK_KPDOT,
+
+	"-":            keybd_event.VK_MINUS,
+	"minus":        keybd_event.VK_MINUS,
+	"=":            keybd_event.VK_EQUAL,
+	"equal":        keybd_event.VK_EQUAL,
+	"[":            keybd_event.VK_LEFTBRACE,
+	"leftbracket":  keybd_event.VK_LEFTBRACE,
+	"]":            keybd_event.VK_RIGHTBRACE,
+	"rightbracket": keybd_event.VK_RIGHTBRACE,
+	";":            keybd_event.VK_SEMICOLON,
+	"semicolon":    keybd_event.VK_SEMICOLON,
+	"'":            keybd_event.VK_APOSTROPHE,
+	"apostrophe":   keybd_event.VK_APOSTROPHE,
+	"`":            keybd_event.VK_GRAVE,
+	"grave":        keybd_event.VK_GRAVE,
+	"\\":           keybd_event.VK_BACKSLASH,
+	"backslash":    keybd_event.VK_BACKSLASH,
+	",":            keybd_event.VK_COMMA,
+	"comma":        keybd_event.VK_COMMA,
+	".":            keybd_event.VK_DOT,
+	"period":       keybd_event.VK_DOT,
+	"/":            keybd_event.VK_SLASH,
+	"slash":        keybd_event.VK_SLASH,
+}
+
+// shiftedKeys are the characters typed by holding shift on a US keyboard, and the key they are on
+var shiftedKeys = map[rune]string{
+	'!': "1", '@': "2", '#': "3", '$': "4", '%': "5", '^': "6", '&': "7", '*': "8", '(': "9", ')': "0",
+	'_': "-", '+': "=", '{': "[", '}': "]", ':': ";", '"': "'", '~': "`", '|': "\\", '<': ",", '>': ".", '?': "/",
+}
+
+// lookupKey finds a key by name, ignoring case
+func lookupKey(name string) (int, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if key, ok := KeyboardMap[name]; ok {
+		return key, true
+	}
+	key, ok := platformKeys[name]
+	return key, ok
 }
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import "github.com/micmonay/keybd_event"
+
+// platformKeys are the keys that keybd_event only has on Linux
+var platformKeys = map[string]int{
+	"num/":        keybd_event.VK_KPSLASH,
+	"numenter":    keybd_event.VK_KPENTER,
+	"printscreen": keybd_event.VK_SYSRQ,
+	"mute":        keybd_event.VK_MUTE,
+	"volumedown":  keybd_event.VK_VOLUMEDOWN,
+	"volumeup":    keybd_event.VK_VOLUMEUP,
+	"playpause":   keybd_event.VK_PLAYPAUSE,
+	"stop":        keybd_event.VK_STOPCD,
+	"nexttrack":   keybd_event.VK_NEXTSONG,
+	"prevtrack":   keybd_event.VK_PREVIOUSSONG,
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import "github.com/micmonay/keybd_event"
+
+// vkDivide is the numpad / key, which keybd_event does not name. Like its other virtual keys, it is offset by 0xFFF.
+const vkDivide = 0x6F + 0xFFF
+
+// platformKeys are the keys that keybd_event only has on Windows
+var platformKeys = map[string]int{
+	"num/":        vkDivide,
+	"printscreen": keybd_event.VK_SNAPSHOT,
+	"mute":        keybd_event.VK_VOLUME_MUTE,
+	"volumedown":  keybd_event.VK_VOLUME_DOWN,
+	"volumeup":    keybd_event.VK_VOLUME_UP,
+	"playpause":   keybd_event.VK_MEDIA_PLAY_PAUSE,
+	"stop":        keybd_event.VK_MEDIA_STOP,
+	"nexttrack":   keybd_event.VK_MEDIA_NEXT_TRACK,
+	"prevtrack":   keybd_event.VK_MEDIA_PREV_TRACK,
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// keyboardCue loads a cue's keyboard action as it would be written in the config, with a recording keyboard
+func keyboardCue(t *testing.T, spec string) (*OSCMap, *recordingKeyboard) {
+	t.Helper()
+
+	var k keyboardSpec
+	if err := yaml.Unmarshal([]byte(spec), &k); err != nil {
+		t.Fatalf("cannot read %s: %v", spec, err)
+	}
+	steps, err := k.steps()
+	if err != nil {
+		t.Fatalf("invalid keyboard %s: %v", spec, err)
+	}
+
+	keyboard := &recordingKeyboard{}
+	m := &OSCMap{keyboard: keyboard}
+	m.loaded.Store(&showSettings{controlMap: map[string]cueMap{"1": {keyboard: steps}}})
+	return m, keyboard
+}
+
+func TestKeyboardChord(t *testing.T) {
+	m, keyboard := keyboardCue(t, `"ctrl+shift+s"`)
+	if err := m.sendKeyboardCommand("1", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keyboard.keys) != 1 {
+		t.Fatalf("sent %v, want one key", keyboard.pressed())
+	}
+	key := keyboard.keys[0]
+	if !key.ctrl || !key.shift || key.alt {
+		t.Errorf("ctrl+shift+s sent with ctrl %v, shift %v, alt %v", key.ctrl, key.shift, key.alt)
+	}
+}
+
+func TestKeyboardWait(t *testing.T) {
+	m, keyboard := keyboardCue(t, `["esc", {wait: 100ms}, "ent"]`)
+
+	started := time.Now()
+	if err := m.sendKeyboardCommand("1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Errorf("sent in %v, want at least the 100ms wait", elapsed)
+	}
+	if want := []string{"esc", "ent"}; !reflect.DeepEqual(keyboard.pressed(), want) {
+		t.Errorf("sent %v, want %v", keyboard.pressed(), want)
+	}
+}
+
+func TestKeyboardType(t *testing.T) {
+	m, keyboard := keyboardCue(t, `{type: "Hi 2!"}`)
+	if err := m.sendKeyboardCommand("1", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"H", "i", " ", "2", "!"}; !reflect.DeepEqual(keyboard.pressed(), want) {
+		t.Fatalf("sent %v, want %v", keyboard.pressed(), want)
+	}
+	shift := make([]bool, len(keyboard.keys))
+	for i, key := range keyboard.keys {
+		shift[i] = key.shift
+	}
+	if want := []bool{true, false, false, false, true}; !reflect.DeepEqual(shift, want) {
+		t.Errorf("shift held %v, want %v", shift, want)
+	}
+}
+
+func TestKeyboardUnknownKey(t *testing.T) {
+	for _, spec := range []string{`"ctrl+nosuchkey"`, `["esc", "hyper+s"]`, `{wait: 0}`, `{type: "café"}`} {
+		var k keyboardSpec
+		if err := yaml.Unmarshal([]byte(spec), &k); err != nil {
+			t.Fatalf("cannot read %s: %v", spec, err)
+		}
+		if _, err := k.steps(); err == nil {
+			t.Errorf("%s was accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultHomeAssistantMaxRate = 10 // requests per second to each house light
+
+// lightStep is a single request to a house light, followed by an optional hold before the next step
+type lightStep struct {
+	rgbw           []int
+	transition     float32
+	effect         string
+	brightnessOnly bool // fade to the brightness of rgbw, keeping the light's current color
+	hold           time.Duration
+}
+
+// lightJob is the list of requests for one cue or effect step on a house light. A job is dropped once
+// its cancel channel is closed, so a stopped effect cannot overwrite the cue that stopped it.
+type lightJob struct {
+	f      *fixture
+	label  string
+	cancel <-chan struct{}
+	steps  []lightStep
+}
+
+func (j *lightJob) cancelled() bool {
+	select {
+	case <-j.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// lightQueue runs a worker per house light for one output, sending each step with send
+type lightQueue struct {
+	send        func(f *fixture, step lightStep) error
+	minInterval time.Duration
+
+	mutex   sync.Mutex
+	workers map[string]*lightWorker
+}
+
+func newLightQueue(minInterval time.Duration, send func(f *fixture, step lightStep) error) *lightQueue {
+	return &lightQueue{
+		send:        send,
+		minInterval: minInterval,
+		workers:     make(map[string]*lightWorker),
+	}
+}
+
+// lightWorker sends the jobs for a single house light one request at a time. Only the newest job is
+// kept, so a job that has not finished when another arrives is abandoned rather than landing last.
+type lightWorker struct {
+	queue *lightQueue
+	wake  chan struct{}
+	last  time.Time
+
+	mutex sync.Mutex
+	next  *lightJob
+}
+
+// queue hands the steps to the fixture's worker, replacing any steps it has not sent yet
+func (q *lightQueue) queue(f *fixture, label string, cancel <-chan struct{}, steps ...lightStep) {
+	q.mutex.Lock()
+	w, ok := q.workers[f.entity]
+	if !ok {
+		w = &lightWorker{queue: q, wake: make(chan struct{}, 1)}
+		q.workers[f.entity] = w
+		go w.run()
+	}
+	q.mutex.Unlock()
+
+	w.submit(&lightJob{f: f, label: label, cancel: cancel, steps: steps})
+}
+
+// queueLight sends the steps to the fixture over its patched output, DMX or Home Assistant
+func (m *OSCMap) queueLight(f *fixture, label string, cancel <-chan struct{}, steps ...lightStep) {
+	if f.dmx != nil {
+		if m.dmx == nil {
+			log.Errorf("Cannot send house light %v on %s, dmx output is not configured", f, label)
+			return
+		}
+		m.dmx.lights.queue(f, label, cancel, steps...)
+		return
+	}
+
+	if m.homeAssistant == nil {
+		log.Errorf("Cannot send house light %v on %s, home assistant is not configured", f, label)
+		return
+	}
+	m.homeAssistant.lights.queue(f, label, cancel, steps...)
+}
+
+func (w *lightWorker) submit(job *lightJob) {
+	w.mutex.Lock()
+	// checked under the lock, as the stop channel is always closed before the newer cue's job is submitted
+	if job.cancelled() {
+		w.mutex.Unlock()
+		return
+	}
+	if w.next != nil {
+		log.Debugf("House light %v dropped %s for %s", job.f, w.next.label, job.label)
+	}
+	w.next = job
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the newest job, if any, and clears it
+func (w *lightWorker) take() *lightJob {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	job := w.next
+	w.next = nil
+	return job
+}
+
+// superseded reports whether a newer job is waiting
+func (w *lightWorker) superseded() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.next != nil
+}
+
+func (w *lightWorker) run() {
+	for range w.wake {
+		for job := w.take(); job != nil; job = w.take() {
+			w.runJob(job)
+		}
+	}
+}
+
+// runJob sends each step of the job no faster than the max rate, giving up as soon as a newer job arrives
+func (w *lightWorker) runJob(job *lightJob) {
+	for _, step := range job.steps {
+		if !w.wait(w.last.Add(w.queue.minInterval), job) {
+			return
+		}
+
+		w.last = time.Now()
+		err := w.queue.send(job.f, step)
+		if err != nil {
+			log.Errorf("House light %v failed on %s: %v", job.f, job.label, err)
+			return
+		}
+
+		if step.hold > 0 && !w.wait(time.Now().Add(step.hold), job) {
+			return
+		}
+	}
+}
+
+// wait sleeps until the deadline, returning false if the job is cancelled or superseded first
+func (w *lightWorker) wait(deadline time.Time, job *lightJob) bool {
+	for {
+		if job.cancelled() || w.superseded() {
+			return false
+		}
+
+		d := time.Until(deadline)
+		if d <= 0 {
+			return true
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+			return !job.cancelled() && !w.superseded()
+		case <-job.cancel:
+			timer.Stop()
+			return false
+		case <-w.wake:
+			// checked again at the top, as the job may already have been taken
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+	log "github.com/sirupsen/logrus"
+)
+
+// lightOwner is whoever currently decides a house light's color
+type lightOwner string
+
+const (
+	OwnerOSCMap     lightOwner = "osc-map"
+	OwnerLightboard lightOwner = "lightboard"
+)
+
+// lightStateSettle is how long after a command osc-map ignores Home Assistant's reports for the light,
+// as they may still be the steps of that command rather than someone else's change
+const lightStateSettle = 1 * time.Second
+
+// lightState is what a house light was last set to, by a cue or as reported by Home Assistant
+type lightState struct {
+	rgbw       []int
+	transition float32
+	effect     string
+	owner      lightOwner
+	cue        string
+	updated    time.Time
+}
+
+// boardState is assumed for lights osc-map has not sent anything to, as the lightboard has them at startup
+var boardState = lightState{
+	rgbw:   []int{0, 0, 0, 0},
+	effect: "Light Board Control",
+	owner:  OwnerLightboard,
+}
+
+// HouseLightStatus is the current state of a single house light
+type HouseLightStatus struct {
+	Light      int       `json:"light"`
+	Name       string    `json:"name,omitempty"`
+	Entity     string    `json:"entity"`
+	Owner      string    `json:"owner"`
+	RGBW       []int     `json:"rgbw"`
+	Transition float32   `json:"transition"`
+	Effect     string    `json:"effect"`
+	Cue        string    `json:"cue,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// lightStates tracks the state of every house light and the state each cue found them in, so that it can be restored
+type lightStates struct {
+	mutex   sync.Mutex
+	current map[int]lightState
+	settled map[int]time.Time
+	before  map[string]map[int]lightState
+}
+
+func newLightStates() *lightStates {
+	return &lightStates{
+		current: make(map[int]lightState),
+		settled: make(map[int]time.Time),
+		before:  make(map[string]map[int]lightState),
+	}
+}
+
+// get returns the state of a light, which must be called with the mutex held
+func (s *lightStates) get(lightID int) lightState {
+	state, ok := s.current[lightID]
+	if !ok {
+		return boardState
+	}
+	return state
+}
+
+// apply records the commands a cue sends, keeping the state each light was in before the cue. A light the cue
+// still holds from an earlier go keeps the state from before that go, so firing a cue twice can still be restored.
+func (s *lightStates) apply(cue string, commands []lightCommand) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	saved := s.before[cue]
+	before := make(map[int]lightState)
+	for _, command := range commands {
+		previous := s.get(command.light)
+		if state, ok := saved[command.light]; ok && previous.cue == cue {
+			before[command.light] = state
+		} else {
+			before[command.light] = previous
+		}
+
+		owner := OwnerOSCMap
+		if command.effect == "Light Board Control" || command.mode == TransitionRelease {
+			owner = OwnerLightboard
+		}
+		rgbw := command.rgbw
+		if command.mode == TransitionBrightnessOnly {
+			rgbw = withBrightness(previous.rgbw, rgbw)
+		}
+		s.current[command.light] = lightState{
+			rgbw:       rgbw,
+			transition: command.transition,
+			effect:     command.effect,
+			owner:      owner,
+			cue:        cue,
+			updated:    now,
+		}
+
+		// "Light Board Control" holds the color for the transition before handing back to the lightboard
+		wait := time.Duration(2*command.transition*float32(time.Second)) + lightStateSettle
+		if command.mode == TransitionRelease && command.hold > command.transition {
+			wait = time.Duration((command.hold+command.transition)*float32(time.Second)) + lightStateSettle
+		}
+		s.settled[command.light] = now.Add(wait)
+	}
+	s.before[cue] = before
+}
+
+// restoreCommands returns the commands to put the lights a cue changed back how the cue found them
+func (s *lightStates) restoreCommands(cue string) ([]lightCommand, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	before, ok := s.before[cue]
+	if !ok {
+		return nil, false
+	}
+
+	commands := make([]lightCommand, 0, len(before))
+	for lightID, state := range before {
+		// lights fade straight back, and lights that were the lightboard's are handed back at once
+		mode := TransitionCrossfade
+		if state.owner == OwnerLightboard {
+			mode = TransitionRelease
+		}
+		commands = append(commands, lightCommand{
+			light:      lightID,
+			rgbw:       state.rgbw,
+			transition: state.transition,
+			effect:     state.effect,
+			mode:       mode,
+		})
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].light < commands[j].light
+	})
+	return commands, true
+}
+
+// release records that the lights have been handed back to the lightboard
+func (s *lightStates) release(lightIDs []int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, lightID := range lightIDs {
+		state := boardState
+		state.updated = now
+		s.current[lightID] = state
+		s.settled[lightID] = now.Add(lightStateSettle)
+	}
+}
+
+// reconcile updates a light's owner from Home Assistant, in case the light was changed by something other than osc-map
+func (s *lightStates) reconcile(f *fixture, haState HAEntityState) {
+	effect, ok := haState.Attributes["effect"].(string)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Now().Before(s.settled[f.number]) {
+		return
+	}
+
+	state := s.get(f.number)
+	if effect == "Light Board Control" && state.owner != OwnerLightboard {
+		log.Infof("House light %v was handed back to the lightboard outside of osc-map", f)
+		state = boardState
+	} else if effect != "Light Board Control" && state.owner == OwnerLightboard {
+		log.Infof("House light %v was taken from the lightboard outside of osc-map", f)
+		state = lightState{
+			rgbw:   haColor(haState.Attributes["rgbw_color"]),
+			effect: effect,
+			owner:  OwnerOSCMap,
+		}
+	} else {
+		return
+	}
+	state.updated = time.Now()
+	s.current[f.number] = state
+}
+
+// haColor reads a color attribute from a Home Assistant state, which is decoded as a list of floats
+func haColor(attribute interface{}) []int {
+	rgbw := []int{0, 0, 0, 0}
+	values, ok := attribute.([]interface{})
+	if !ok {
+		return rgbw
+	}
+	for i, v := range values {
+		if c, ok := v.(float64); ok && i < len(rgbw) {
+			rgbw[i] = int(c)
+		}
+	}
+	return rgbw
+}
+
+// HouseLightStatus returns the current state of every house light, in fixture order
+func (m *OSCMap) HouseLightStatus() []HouseLightStatus {
+	m.lights.mutex.Lock()
+	defer m.lights.mutex.Unlock()
+
+	fixtures := m.settings().fixtures
+	statuses := make([]HouseLightStatus, 0, len(fixtures.numbers))
+	for _, number := range fixtures.numbers {
+		f := fixtures.byNumber[number]
+		state := m.lights.get(number)
+		statuses = append(statuses, HouseLightStatus{
+			Light:      f.number,
+			Name:       f.name,
+			Entity:     f.entity,
+			Owner:      string(state.owner),
+			RGBW:       state.rgbw,
+			Transition: state.transition,
+			Effect:     state.effect,
+			Cue:        state.cue,
+			Updated:    state.updated,
+		})
+	}
+	return statuses
+}
+
+// sendHouseLightStatus sends the state of every house light to the OSC status client.
+//
+// /osc-map/houselights/count <int> is sent first, followed by one /osc-map/houselights/<n> message per light
+// with the arguments light number, name, owner, effect, red, green, blue, white and the cue that last set it.
+func (m *OSCMap) sendHouseLightStatus() {
+	if m.oscStatusClient == nil {
+		return
+	}
+
+	statuses := m.HouseLightStatus()
+
+	err := m.oscStatusClient.Send(osc.NewMessage("/osc-map/houselights/count", int32(len(statuses))))
+	if err != nil {
+		log.Errorf("Failed to send house light status: %v", err)
+		return
+	}
+
+	for i, s := range statuses {
+		msg := osc.NewMessage(fmt.Sprintf("/osc-map/houselights/%d", i+1),
+			int32(s.Light),
+			s.Name,
+			s.Owner,
+			s.Effect)
+		for _, c := range s.RGBW {
+			msg.Append(int32(c))
+		}
+		msg.Append(s.Cue)
+
+		err := m.oscStatusClient.Send(msg)
+		if err != nil {
+			log.Errorf("Failed to send house light status: %v", err)
+			return
+		}
+	}
+}
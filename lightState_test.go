@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestoreAfterRefire(t *testing.T) {
+	s := newLightStates()
+	blue := lightCommand{light: 1, rgbw: []int{0, 0, 255, 0}, effect: "None", mode: TransitionCrossfade}
+	red := lightCommand{light: 1, rgbw: []int{255, 0, 0, 0}, effect: "None", mode: TransitionCrossfade}
+	green := lightCommand{light: 1, rgbw: []int{0, 255, 0, 0}, effect: "None", mode: TransitionCrossfade}
+
+	s.apply("1", []lightCommand{blue})
+	s.apply("2", []lightCommand{red})
+	s.apply("2", []lightCommand{red})
+
+	commands, ok := s.restoreCommands("2")
+	if !ok || len(commands) != 1 {
+		t.Fatalf("restore of cue 2 gave %v, %v", commands, ok)
+	}
+	if !reflect.DeepEqual(commands[0].rgbw, blue.rgbw) {
+		t.Errorf("cue 2 fired twice restores %v, want the %v from before it", commands[0].rgbw, blue.rgbw)
+	}
+
+	// once another cue has changed the light, firing cue 2 again saves that cue's look instead
+	s.apply("3", []lightCommand{green})
+	s.apply("2", []lightCommand{red})
+	commands, _ = s.restoreCommands("2")
+	if !reflect.DeepEqual(commands[0].rgbw, green.rgbw) {
+		t.Errorf("cue 2 after cue 3 restores %v, want %v", commands[0].rgbw, green.rgbw)
+	}
+}
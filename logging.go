@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultLogLevel = "info"
+
+// logLevel parses log.level from the config
+func logLevel(c confLog) (log.Level, error) {
+	if c.Level == "" {
+		return log.ParseLevel(DefaultLogLevel)
+	}
+	level, err := log.ParseLevel(c.Level)
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", c.Level)
+	}
+	return level, nil
+}
+
+// logPath fills in {date} in a log file name, so that each night of a run can have its own file
+func logPath(path string, now time.Time) string {
+	return strings.ReplaceAll(path, "{date}", now.Format("2006-01-02"))
+}
+
+// openLogFile opens a log file for appending, creating its directory if needed
+func openLogFile(path string) (*os.File, error) {
+	path = logPath(path, time.Now())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// setupLogging applies log.format and opens log.file. The file is returned so that it can be kept when the
+// terminal output is replaced, or nil if the log only goes to the terminal.
+func setupLogging(c confLog) (io.Writer, error) {
+	switch strings.ToLower(c.Format) {
+	case "", "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", c.Format)
+	}
+
+	if c.File == "" {
+		return nil, nil
+	}
+	file, err := openLogFile(c.File)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, file))
+	return file, nil
+}
+
+// eventLog writes one JSON line for every fired cue once all of its outputs have finished, for osc-map report
+type eventLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func openEventLog(path string) (*eventLog, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (l *eventLog) write(r CueRecord) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.encoder.Encode(r); err != nil {
+		log.Errorf("Failed to write cue[%v] to the event log: %v", r.Cue, err)
+	}
+}
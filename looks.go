@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lightCommand is the color, transition and effect to send to one house light on a cue
+type lightCommand struct {
+	light      int
+	rgbw       []int
+	transition float32
+	effect     string
+	mode       transitionMode
+	hold       float32 // seconds before a release hands the light back to the lightboard
+}
+
+// lightCommands expands houselights entries, which may be fixtures or groups, into one command per light.
+// Each rgbw, transition, effect, mode and hold applies to the entry at the same position, or to every entry if only
+// one is given. The modes and holds may be left out.
+func (p *fixturePatch) lightCommands(refs []string, rgbws []colorSpec, transitions []float32, effects []string, modes []string, holds []float32) ([]lightCommand, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	// Check length errors
+	if len(refs) != len(transitions) && len(transitions) != 1 {
+		return nil, fmt.Errorf("unmatched transitions list length to number of lights")
+	}
+	if len(refs) != len(effects) && len(effects) != 1 {
+		return nil, fmt.Errorf("unmatched effects list length to number of lights")
+	}
+	if len(refs) != len(rgbws) && len(rgbws) != 1 {
+		return nil, fmt.Errorf("unmatched RGBWs list length to number of lights")
+	}
+	if len(refs) != len(modes) && len(modes) > 1 {
+		return nil, fmt.Errorf("unmatched transition modes list length to number of lights")
+	}
+	if len(refs) != len(holds) && len(holds) > 1 {
+		return nil, fmt.Errorf("unmatched holds list length to number of lights")
+	}
+
+	var commands []lightCommand
+	for i, ref := range refs {
+		fixtures, err := p.resolveAll(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		var effect string
+		if len(effects) == 1 {
+			effect = effects[0]
+		} else {
+			effect = effects[i]
+		}
+		var transition float32
+		if len(transitions) == 1 {
+			transition = transitions[0]
+		} else {
+			transition = transitions[i]
+		}
+		if transition < 0 {
+			return nil, fmt.Errorf("negative transition %v for %s", transition, ref)
+		}
+		var color colorSpec
+		if len(rgbws) == 1 {
+			color = rgbws[0]
+		} else {
+			color = rgbws[i]
+		}
+
+		rgbw, err := color.rgbw()
+		if err != nil {
+			return nil, fmt.Errorf("invalid color for %s: %v", ref, err)
+		}
+
+		var modeName string
+		if len(modes) == 1 {
+			modeName = modes[0]
+		} else if len(modes) > 1 {
+			modeName = modes[i]
+		}
+		mode, err := parseTransitionMode(modeName, effect)
+		if err != nil {
+			return nil, err
+		}
+
+		// a release holds the color for the transition unless told otherwise
+		hold := transition
+		if len(holds) == 1 {
+			hold = holds[0]
+		} else if len(holds) > 1 {
+			hold = holds[i]
+		}
+		if hold < 0 {
+			return nil, fmt.Errorf("negative hold %v for %s", hold, ref)
+		}
+
+		for _, f := range fixtures {
+			commands = append(commands, lightCommand{
+				light:      f.number,
+				rgbw:       rgbw,
+				transition: transition,
+				effect:     effect,
+				mode:       mode,
+				hold:       hold,
+			})
+		}
+	}
+
+	return commands, nil
+}
+
+// buildLooks resolves every named look into its light commands
+func (p *fixturePatch) buildLooks(confLooks map[string][]confLookEntry) (map[string][]lightCommand, error) {
+	looks := make(map[string][]lightCommand)
+	for name, entries := range confLooks {
+		var commands []lightCommand
+		for _, entry := range entries {
+			// a look takes the lights away from the lightboard unless told otherwise
+			effect := entry.Effect
+			if effect == "" {
+				effect = "None"
+			}
+
+			var holds []float32
+			if entry.Hold != nil {
+				holds = []float32{*entry.Hold}
+			}
+
+			entryCommands, err := p.lightCommands(entry.HouseLights, []colorSpec{entry.RGBW}, []float32{entry.Transition}, []string{effect}, []string{entry.TransitionMode}, holds)
+			if err != nil {
+				return nil, fmt.Errorf("look %q: %v", name, err)
+			}
+			commands = append(commands, entryCommands...)
+		}
+		looks[strings.ToLower(name)] = commands
+	}
+
+	return looks, nil
+}
+
+// mergeLightCommands combines commands in order, with later commands for the same light replacing earlier ones
+func mergeLightCommands(lists ...[]lightCommand) []lightCommand {
+	var merged []lightCommand
+	index := make(map[int]int)
+	for _, list := range lists {
+		for _, command := range list {
+			if i, ok := index[command.light]; ok {
+				merged[i] = command
+				continue
+			}
+			index[command.light] = len(merged)
+			merged = append(merged, command)
+		}
+	}
+
+	return merged
+}
@@ -2,17 +2,19 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"runtime"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/faiface/beep"
 	"github.com/faiface/beep/speaker"
 	"github.com/hypebeast/go-osc/osc"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/micmonay/keybd_event"
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/drivers"
 	_ "gitlab.com/gomidi/midi/v2/drivers/rtmididrv" // autoregisters driver
@@ -29,61 +31,61 @@ const (
 	DefaultResampleQuality   = 4    // good balance of quality and playback time
 	DefaultHomeAssistantHTTP = "http://homeassistant.local"
 	DefaultHomeAssistantPort = 80
-	NumHouseLights           = 15
 )
 
 type OSCMap struct {
-	oscDispatcher  *osc.StandardDispatcher
-	oscInServer    *osc.Server
-	oscOutClient   *osc.Client
-	midiOut        *drivers.Out
-	qlabOut        *drivers.Out
-	midiOutChannel uint8
-	controlMap     map[string]cueMap
-	keyBonding     *keybd_event.KeyBonding
+	oscDispatcher   *osc.StandardDispatcher
+	oscInServer     *osc.Server
+	oscOutClient    *osc.Client
+	oscStatusClient *osc.Client
+	midiOut         *drivers.Out
+	qlabOut         *drivers.Out
+	homeAssistant   *HomeAssistant
+	dmx             *dmxOutput
+	mqtt            *mqttClient
+	midiOutChannel  uint8
+	loaded          atomic.Pointer[showSettings] // replaced by every reload, read with settings()
+	lights          *lightStates
+	keyboard        keyboardBackend
+	keyboardWindow  string
+	keyboardMutex   sync.Mutex
+	history         *cueHistory
+	eventLog        *eventLog
+	configFile      string
+	configMutex     sync.Mutex
+	showChanged     chan struct{}
+	lightboardLast  atomic.Int64 // unix nanoseconds of the last message from the lightboard
+
+	speakerSampleRate beep.SampleRate
 }
 
-// There are 15 house lights and each needs a stop channel for custom effects
-var stopChannels = make([]chan struct{}, NumHouseLights)
-
-func listenForOSC(m *OSCMap, responseChannel chan bool) {
+func listenForOSC(m *OSCMap, allStopAddress string, responseChannel chan bool) {
 	m.oscDispatcher.AddMsgHandler("/cs/out/ping", func(msg *osc.Message) {
-		// Check ping response
-		responseChannel <- true
-	})
+		m.lightboardSeen()
 
-	// ExtractDecimal extracts the decimal part from a string in the format "decimal_label"
-	ExtractDecimal := func(input string) string {
-		// Find the position of the underscore
-		underscoreIndex := strings.Index(input, "_")
-		if underscoreIndex == -1 {
-			// If no underscore is found, return the entire string (assuming it's just the decimal)
-			return input
+		// Check ping response, only the first is waited for
+		select {
+		case responseChannel <- true:
+		default:
 		}
-		// Return the substring before the underscore
-		return input[:underscoreIndex]
-	}
-
-	// Handle cue numbers
-	m.oscDispatcher.AddMsgHandler("/cs/out/playback/go", func(msg *osc.Message) {
-		cueNumber := fmt.Sprintf("%v", msg.Arguments[0])
+	})
 
-		// Trim the cue label and underscore
-		cueNumber = ExtractDecimal(cueNumber)
+	// Respond to status queries immediately rather than waiting for the next report
+	m.oscDispatcher.AddMsgHandler("/osc-map/audio/status", func(msg *osc.Message) {
+		go m.sendPlaybackStatus()
+	})
+	m.oscDispatcher.AddMsgHandler("/osc-map/houselights/status", func(msg *osc.Message) {
+		go m.sendHouseLightStatus()
+	})
 
-		// If cue number ends in 0, make an optional second to test
-		cueInteger := strings.Clone(cueNumber)
-		if strings.Contains(cueInteger, ".0") {
-			cueInteger = strings.ReplaceAll(cueInteger, ".0", "")
-		}
-		log.Infof("Received cue number: %v", cueNumber)
+	m.oscDispatcher.AddMsgHandler(allStopAddress, func(msg *osc.Message) {
+		go m.AllStop()
+	})
 
-		go m.sendMidiOut(cueNumber, cueInteger)
-		if m.keyBonding != nil {
-			go m.sendKeyboardCommand(cueNumber, cueInteger)
-		}
-		go m.playAudioFile(cueNumber, cueInteger)
-		go m.toggleLight(cueNumber, cueInteger)
+	// Handle cue numbers
+	m.oscDispatcher.AddMsgHandler("/cs/out/playback/go", func(msg *osc.Message) {
+		m.lightboardSeen()
+		m.fireCue(fmt.Sprintf("%v", msg.Arguments[0]), "lightboard", msg.Arguments...)
 	})
 
 	err := m.oscInServer.ListenAndServe()
@@ -93,19 +95,50 @@ func listenForOSC(m *OSCMap, responseChannel chan bool) {
 }
 
 func main() {
+	// osc-map console runs an interactive prompt alongside the OSC listener, osc-map report only reads event logs
+	consoleMode := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "console":
+			consoleMode = true
+		case "report":
+			if err := runReport(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to write report: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q, run osc-map on its own, osc-map console or osc-map report", os.Args[1])
+		}
+	}
+
 	time.Sleep(5 * time.Second)
 	defer midi.CloseDriver()
 
-	log.SetLevel(log.DebugLevel)
-
-	oscMap := &OSCMap{}
-	conf, err := oscMap.readConfig()
+	oscMap := &OSCMap{
+		lights:      newLightStates(),
+		history:     newCueHistory(DefaultCueHistory),
+		configFile:  DefaultConfigFile,
+		showChanged: make(chan struct{}, 1),
+	}
+	conf, err := oscMap.readConfig(oscMap.configFile)
 	if err != nil {
 		log.Fatalf("Failed to read config file: %v", err)
 	}
+
+	// the log destination and event log are only set up at start up
+	logFile, err := setupLogging(conf.Log)
+	if err != nil {
+		log.Fatalf("Invalid log settings: %v", err)
+	}
+	if conf.Log.Events != "" {
+		oscMap.eventLog, err = openEventLog(conf.Log.Events)
+		if err != nil {
+			log.Fatalf("Failed to open event log: %v", err)
+		}
+	}
 	go oscMap.monitorConfig()
 
-	log.Debugf("Final cue mapping: %v", oscMap.controlMap)
+	log.Debugf("Final cue mapping: %v", oscMap.settings().controlMap)
 
 	// set up osc dispatcher and server
 	oscMap.oscDispatcher = osc.NewStandardDispatcher()
@@ -118,6 +151,17 @@ func main() {
 	// set up osc send client
 	oscMap.oscOutClient = osc.NewClient(conf.Outputs.OSCOut.IP.String(), conf.Outputs.OSCOut.Port)
 
+	// set up osc status client for the operator's tablet
+	if conf.Outputs.OSCStatus.IP != nil {
+		oscMap.oscStatusClient = osc.NewClient(conf.Outputs.OSCStatus.IP.String(), conf.Outputs.OSCStatus.Port)
+
+		interval := conf.Outputs.OSCStatus.Interval
+		if interval <= 0 {
+			interval = DefaultStatusInterval
+		}
+		go oscMap.reportPlaybackStatus(time.Duration(interval * float32(time.Second)))
+	}
+
 	quit := false
 
 	// connect to midi output
@@ -140,28 +184,62 @@ func main() {
 		}
 	}
 
-	for i := 0; i < NumHouseLights; i++ {
-		stopChannels[i] = make(chan struct{})
+	// house lights are optional, so a missing token is not fatal
+	ha, err := newHomeAssistant(conf.Outputs.HomeAssistant, func(entityID string) bool {
+		return oscMap.settings().fixtures.byEntity[entityID] != nil
+	}, func(state HAEntityState) {
+		if f := oscMap.settings().fixtures.byEntity[state.EntityID]; f != nil {
+			oscMap.lights.reconcile(f, state)
+		}
+	})
+	if err != nil {
+		log.Errorf("House light control disabled: %v", err)
+	} else {
+		oscMap.homeAssistant = ha
+	}
+
+	if conf.Outputs.DMX.Protocol != "" {
+		dmx, err := newDMXOutput(conf.Outputs.DMX, func() *fixturePatch {
+			return oscMap.settings().fixtures
+		})
+		if err != nil {
+			log.Errorf("DMX output disabled: %v", err)
+		} else {
+			oscMap.dmx = dmx
+		}
+	}
+
+	if conf.Outputs.MQTT.Broker != "" {
+		client, err := newMQTTClient(conf.Outputs.MQTT)
+		if err != nil {
+			log.Errorf("MQTT output disabled: %v", err)
+		} else {
+			client.subscribe(mqttAckTopics(oscMap.settings().controlMap))
+			oscMap.mqtt = client
+			go client.run()
+		}
 	}
 
 	if conf.Outputs.AudioFiles {
-		speaker.Init(DefaultSampleRate, DefaultBufferSize)
+		audio := oscMap.settings().audio
+		err := speaker.Init(audio.sampleRate, audio.bufferSize)
+		if err != nil {
+			log.Errorf("Failed to initialize speaker: %v", err)
+			quit = true
+		} else {
+			oscMap.speakerSampleRate = audio.sampleRate
+		}
 	}
 
+	// the keyboard is set up in the background, as uinput needs a moment before it can be used
 	if conf.Outputs.KeyboardCommands {
-		kb, err := keybd_event.NewKeyBonding()
+		keyboard, err := newKeyboardBackend(conf.Outputs.KeyboardBackend)
 		if err != nil {
-			log.Errorf("Failed to create key bonding: %v", err)
+			log.Errorf("Failed to create keyboard: %v", err)
 			quit = true
 		} else {
-
-			// For linux, it is very important to wait 2 seconds
-			if runtime.GOOS == "linux" {
-				log.Info("Please wait 2 seconds for keyboard binding...")
-				time.Sleep(2 * time.Second)
-			}
-
-			oscMap.keyBonding = &kb
+			oscMap.keyboard = keyboard
+			oscMap.keyboardWindow = conf.Outputs.KeyboardWindow
 		}
 	}
 
@@ -171,7 +249,11 @@ func main() {
 
 	// Ping the Colorsource AV to open a loopback, then listen for cue numbers
 	responseChannel := make(chan bool, 1)
-	go listenForOSC(oscMap, responseChannel)
+	allStopAddress := conf.AllStop.OSCAddress
+	if allStopAddress == "" {
+		allStopAddress = DefaultAllStopOSCAddress
+	}
+	go listenForOSC(oscMap, allStopAddress, responseChannel)
 
 	pingMessage := osc.NewMessage("/cs/ping", "1")
 	if err := oscMap.oscOutClient.Send(pingMessage); err != nil {
@@ -186,12 +268,39 @@ func main() {
 		log.Infof("Successfully received a ping response from %v:%v", conf.Outputs.OSCOut.IP.String(), conf.Outputs.OSCOut.Port)
 		timer.Stop()
 	case <-timer.C:
-		log.Fatalf("No ping response detected from %v:%v", conf.Outputs.OSCOut.IP.String(), conf.Outputs.OSCOut.Port)
-		return
+		// cues can still be fired from the console while the lightboard is down
+		if !consoleMode {
+			log.Fatalf("No ping response detected from %v:%v", conf.Outputs.OSCOut.IP.String(), conf.Outputs.OSCOut.Port)
+			return
+		}
+		log.Warnf("No ping response detected from %v:%v", conf.Outputs.OSCOut.IP.String(), conf.Outputs.OSCOut.Port)
+	}
+
+	// outside Windows the hotkey is typed into the terminal, which the console reads instead and treats as stop
+	if conf.AllStop.Hotkey != "" && !(consoleMode && runtime.GOOS != "windows") {
+		go oscMap.listenForAllStopHotkey(conf.AllStop.Hotkey)
+	}
+
+	if conf.Web.Port != 0 {
+		go oscMap.servePanel(conf.Web)
 	}
 
 	log.Infof("Listening for OSC from %v:%v, outputting OSC to %s:%d and MIDI to %s", conf.OSCIn.IP, conf.OSCIn.Port, conf.Outputs.OSCOut.IP, conf.Outputs.OSCOut.Port, conf.Outputs.MIDIPC.Name)
 
+	if consoleMode {
+		c := newConsole(oscMap, os.Stdout)
+		if logFile != nil {
+			log.SetOutput(io.MultiWriter(c, logFile))
+		} else {
+			log.SetOutput(c)
+		}
+		c.printf("Type help for the list of commands")
+		c.run(os.Stdin)
+		log.SetOutput(os.Stderr)
+		fmt.Println("Quitting")
+		return
+	}
+
 	// listen for ctrl+c
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
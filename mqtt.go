@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect      byte = 1
+	mqttConnAck      byte = 2
+	mqttPublish      byte = 3
+	mqttPubAck       byte = 4
+	mqttPubRec       byte = 5
+	mqttPubRel       byte = 6
+	mqttPubComp      byte = 7
+	mqttSubscribe    byte = 8
+	mqttSubAck       byte = 9
+	mqttPingReq      byte = 12
+	mqttPingResp     byte = 13
+	mqttDisconnect   byte = 14
+	mqttMaxRemaining      = 268435455
+)
+
+var errMQTTMalformed = errors.New("malformed mqtt packet")
+
+// mqttPacket is a single MQTT control packet, with the flags from the low bits of the first byte
+type mqttPacket struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// writeMQTTPacket writes the fixed header, with its variable length remaining length, followed by the body
+func writeMQTTPacket(w io.Writer, kind byte, flags byte, body []byte) error {
+	if len(body) > mqttMaxRemaining {
+		return fmt.Errorf("mqtt packet of %d bytes is too large", len(body))
+	}
+
+	header := []byte{kind<<4 | flags}
+	remaining := len(body)
+	for {
+		b := byte(remaining % 128)
+		remaining /= 128
+		if remaining > 0 {
+			b |= 0x80
+		}
+		header = append(header, b)
+		if remaining == 0 {
+			break
+		}
+	}
+
+	_, err := w.Write(append(header, body...))
+	return err
+}
+
+func readMQTTPacket(r *bufio.Reader) (mqttPacket, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return mqttPacket{}, err
+	}
+
+	remaining, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return mqttPacket{}, errMQTTMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return mqttPacket{}, err
+		}
+		remaining += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, remaining)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return mqttPacket{}, err
+	}
+
+	return mqttPacket{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errMQTTMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errMQTTMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// encodeConnect builds the body of a clean session CONNECT
+func encodeConnect(clientID string, username string, password string, keepalive uint16) []byte {
+	flags := byte(0x02)
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, keepalive)
+	body = appendMQTTString(body, clientID)
+	if username != "" {
+		body = appendMQTTString(body, username)
+		if password != "" {
+			body = appendMQTTString(body, password)
+		}
+	}
+	return body
+}
+
+// connAckError explains a refused CONNACK return code
+func connAckError(code byte) error {
+	reasons := map[byte]string{
+		1: "unacceptable protocol version",
+		2: "client id rejected",
+		3: "server unavailable",
+		4: "bad user name or password",
+		5: "not authorized",
+	}
+	reason, ok := reasons[code]
+	if !ok {
+		reason = fmt.Sprintf("return code %d", code)
+	}
+	return fmt.Errorf("mqtt broker refused connection: %s", reason)
+}
+
+// publishFlags packs the QoS and retain flag of a PUBLISH
+func publishFlags(qos byte, retain bool) byte {
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	return flags
+}
+
+func encodePublish(topic string, id uint16, qos byte, payload []byte) []byte {
+	body := appendMQTTString(nil, topic)
+	if qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return append(body, payload...)
+}
+
+// mqttMessage is a PUBLISH received from the broker
+type mqttMessage struct {
+	topic   string
+	id      uint16
+	qos     byte
+	payload []byte
+}
+
+func decodePublish(p mqttPacket) (mqttMessage, error) {
+	msg := mqttMessage{qos: (p.flags >> 1) & 0x03}
+
+	topic, rest, err := readMQTTString(p.body)
+	if err != nil {
+		return msg, err
+	}
+	msg.topic = topic
+
+	if msg.qos > 0 {
+		if len(rest) < 2 {
+			return msg, errMQTTMalformed
+		}
+		msg.id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	msg.payload = rest
+
+	return msg, nil
+}
+
+// encodePacketID builds the body of the acknowledgement packets, which only hold a packet id
+func encodePacketID(id uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, id)
+}
+
+func decodePacketID(p mqttPacket) (uint16, error) {
+	if len(p.body) < 2 {
+		return 0, errMQTTMalformed
+	}
+	return binary.BigEndian.Uint16(p.body), nil
+}
+
+func encodeSubscribe(id uint16, filters []string, qos byte) []byte {
+	body := encodePacketID(id)
+	for _, filter := range filters {
+		body = appendMQTTString(body, filter)
+		body = append(body, qos)
+	}
+	return body
+}
+
+// topicMatches reports whether a topic matches a subscription filter with + and # wildcards
+func topicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
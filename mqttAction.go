@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mqttAction is a message published when a cue runs, optionally waiting for the device to acknowledge it
+type mqttAction struct {
+	topic      string
+	payload    *template.Template
+	qos        byte
+	retain     bool
+	ack        string
+	ackPayload *string
+}
+
+// buildMQTTActions checks a cue's mqtt list and parses the payload templates
+func buildMQTTActions(actions []confMQTTAction) ([]mqttAction, error) {
+	var built []mqttAction
+	for i, a := range actions {
+		if a.Topic == "" {
+			return nil, fmt.Errorf("mqtt action %d needs a topic", i+1)
+		}
+		if strings.ContainsAny(a.Topic, "+#") {
+			return nil, fmt.Errorf("mqtt topic %q cannot contain wildcards", a.Topic)
+		}
+		if a.QoS > 2 {
+			return nil, fmt.Errorf("mqtt topic %q has qos %d, use 0, 1 or 2", a.Topic, a.QoS)
+		}
+		if a.AckPayload != nil && a.Ack == "" {
+			return nil, fmt.Errorf("mqtt topic %q has an ack-payload without an ack topic", a.Topic)
+		}
+
+		payload, err := parseCueTemplate(a.Topic, a.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt topic %q has an invalid payload: %v", a.Topic, err)
+		}
+
+		built = append(built, mqttAction{
+			topic:      a.Topic,
+			payload:    payload,
+			qos:        a.QoS,
+			retain:     a.Retain,
+			ack:        a.Ack,
+			ackPayload: a.AckPayload,
+		})
+	}
+	return built, nil
+}
+
+// mqttAckTopics lists every acknowledgement topic used by the cues, so they can be subscribed to up front
+func mqttAckTopics(controlMap map[string]cueMap) []string {
+	seen := make(map[string]bool)
+	var topics []string
+	for _, cm := range controlMap {
+		for _, a := range cm.mqtt {
+			if a.ack != "" && !seen[a.ack] {
+				seen[a.ack] = true
+				topics = append(topics, a.ack)
+			}
+		}
+	}
+	return topics
+}
+
+// sendMQTT publishes the cue's messages in order, then waits for any acknowledgements
+func (m *OSCMap) sendMQTT(cueNumber string, cueInteger string) error {
+	controlMap := m.settings().controlMap
+	mc, ok := controlMap[cueNumber]
+	if !ok {
+		mc, ok = controlMap[cueInteger]
+		if !ok {
+			log.Debugf("No mqtt messages for cue[%v]", cueNumber)
+			return nil
+		}
+	}
+
+	if len(mc.mqtt) == 0 {
+		return nil
+	}
+	if m.mqtt == nil {
+		return cueError("Cannot send mqtt messages for cue[%v], no mqtt broker is configured", cueNumber)
+	}
+
+	data := newCueTemplateData(cueNumber)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errs []error
+	fail := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	}
+	for _, a := range mc.mqtt {
+		var payload bytes.Buffer
+		if err := a.payload.Execute(&payload, data); err != nil {
+			fail(cueError("Failed to build mqtt payload for %s on cue[%v]: %v", a.topic, cueNumber, err))
+			continue
+		}
+
+		// wait before publishing, so a fast reply is not missed
+		var waiter *mqttWaiter
+		if a.ack != "" {
+			waiter = m.mqtt.expect(a.ack, a.ackPayload)
+		}
+
+		if err := m.mqtt.publish(a.topic, payload.Bytes(), a.qos, a.retain); err != nil {
+			fail(cueError("Failed to publish mqtt message to %s on cue[%v]: %v", a.topic, cueNumber, err))
+			if waiter != nil {
+				m.mqtt.wait(waiter, 0)
+			}
+			continue
+		}
+		log.Infof("Published mqtt message to %s: %s", a.topic, payload.String())
+
+		if waiter == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(a mqttAction) {
+			defer wg.Done()
+			if reply, ok := m.mqtt.wait(waiter, m.mqtt.timeout); ok {
+				log.Infof("MQTT message to %s acknowledged on %s: %s", a.topic, a.ack, reply)
+			} else {
+				err := fmt.Errorf("no acknowledgement on %s for mqtt message to %s on cue[%v]", a.ack, a.topic, cueNumber)
+				log.Warn(err)
+				fail(err)
+			}
+		}(a)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMQTTPort       = 1883
+	DefaultMQTTTLSPort    = 8883
+	DefaultMQTTClientID   = "osc-map"
+	DefaultMQTTKeepalive  = 30 // seconds
+	DefaultMQTTAckTimeout = 2  // seconds
+
+	mqttReconnectMin = 1 * time.Second
+	mqttReconnectMax = 30 * time.Second
+)
+
+// errMQTTNotConnected is returned when a message is published while the broker connection is down
+var errMQTTNotConnected = errors.New("mqtt broker not connected")
+
+// mqttClient is a persistent connection to an MQTT broker. It reconnects on its own, resubscribing to
+// every acknowledgement topic, and hands each received message to whoever is waiting for it.
+type mqttClient struct {
+	address   string
+	tlsConfig *tls.Config
+	clientID  string
+	username  string
+	password  string
+	keepalive time.Duration
+	timeout   time.Duration
+
+	writeMutex sync.Mutex
+
+	mutex         sync.Mutex
+	conn          net.Conn
+	nextID        uint16
+	pending       map[uint16]chan mqttPacket
+	subscriptions map[string]bool
+	waiters       map[*mqttWaiter]bool
+}
+
+// mqttWaiter is waiting for a message on a topic, with a particular payload if expected is set
+type mqttWaiter struct {
+	topic    string
+	expected *string
+	received chan string
+}
+
+// newMQTTClient creates the client described by outputs.mqtt. It does not connect until run is called.
+func newMQTTClient(c confMQTT) (*mqttClient, error) {
+	broker, err := url.Parse(c.Broker)
+	if err != nil || broker.Host == "" {
+		return nil, fmt.Errorf("invalid mqtt broker %q, use e.g. tcp://host:1883", c.Broker)
+	}
+
+	// the keepalive is sent to the broker as a 16 bit number of seconds
+	if c.Keepalive != 0 && (c.Keepalive < 1 || c.Keepalive > 65535) {
+		return nil, fmt.Errorf("invalid mqtt keepalive %v, use 1 to 65535 seconds", c.Keepalive)
+	}
+
+	client := &mqttClient{
+		clientID:      c.ClientID,
+		username:      c.Username,
+		keepalive:     time.Duration(c.Keepalive * float32(time.Second)),
+		pending:       make(map[uint16]chan mqttPacket),
+		subscriptions: make(map[string]bool),
+		waiters:       make(map[*mqttWaiter]bool),
+	}
+	if client.clientID == "" {
+		client.clientID = DefaultMQTTClientID
+	}
+	if client.keepalive == 0 {
+		client.keepalive = DefaultMQTTKeepalive * time.Second
+	}
+
+	timeout := c.AckTimeout
+	if timeout <= 0 {
+		timeout = DefaultMQTTAckTimeout
+	}
+	client.timeout = time.Duration(timeout * float32(time.Second))
+
+	if c.PasswordEnv != "" {
+		client.password = os.Getenv(c.PasswordEnv)
+		if client.password == "" {
+			return nil, fmt.Errorf("no mqtt password in $%s", c.PasswordEnv)
+		}
+	}
+
+	port := DefaultMQTTPort
+	switch broker.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		port = DefaultMQTTTLSPort
+		client.tlsConfig = &tls.Config{
+			ServerName:         broker.Hostname(),
+			InsecureSkipVerify: c.InsecureSkipVerify,
+		}
+	default:
+		return nil, fmt.Errorf("unknown mqtt broker scheme %q, use tcp or ssl", broker.Scheme)
+	}
+
+	client.address = broker.Host
+	if broker.Port() == "" {
+		client.address = net.JoinHostPort(broker.Hostname(), fmt.Sprint(port))
+	}
+
+	return client, nil
+}
+
+// run keeps the broker connected for the life of the program
+func (c *mqttClient) run() {
+	backoff := mqttReconnectMin
+	for {
+		err := c.connect()
+		if err == nil {
+			log.Infof("Connected to mqtt broker %s", c.address)
+			backoff = mqttReconnectMin
+			err = c.readLoop()
+		}
+		c.disconnect()
+
+		log.Errorf("MQTT broker %s: %v, reconnecting in %v", c.address, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > mqttReconnectMax {
+			backoff = mqttReconnectMax
+		}
+	}
+}
+
+// connect dials the broker and starts a clean session, then resubscribes and keeps the connection alive in the background
+func (c *mqttClient) connect() error {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+
+	keepalive := uint16(c.keepalive / time.Second)
+	if err := writeMQTTPacket(conn, mqttConnect, 0, encodeConnect(c.clientID, c.username, c.password, keepalive)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(c.timeout))
+	reader := bufio.NewReader(conn)
+	p, err := readMQTTPacket(reader)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("expected CONNACK: %v", err)
+	}
+	if p.kind != mqttConnAck || len(p.body) < 2 {
+		conn.Close()
+		return fmt.Errorf("expected CONNACK, got packet type %d", p.kind)
+	}
+	if p.body[1] != 0 {
+		conn.Close()
+		return connAckError(p.body[1])
+	}
+
+	session := &bufferedConn{Conn: conn, reader: reader}
+	c.mutex.Lock()
+	c.conn = session
+	filters := make([]string, 0, len(c.subscriptions))
+	for filter := range c.subscriptions {
+		filters = append(filters, filter)
+	}
+	c.mutex.Unlock()
+
+	// the read loop has to be running before these can be answered
+	go func() {
+		if len(filters) != 0 {
+			if err := c.sendSubscribe(filters); err != nil {
+				log.Errorf("Failed to subscribe to mqtt acknowledgements: %v", err)
+			}
+		}
+		c.ping(session)
+	}()
+
+	return nil
+}
+
+// bufferedConn reads through the reader that already holds the start of the session
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+// ping sends a PINGREQ at half the keepalive until the connection drops. It stops once conn has been
+// replaced, so a reconnect that happens between ticks does not leave two of it pinging the new session.
+func (c *mqttClient) ping(conn net.Conn) {
+	ticker := time.NewTicker(c.keepalive / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mutex.Lock()
+		current := c.conn
+		c.mutex.Unlock()
+		if current != conn {
+			return
+		}
+		if err := c.write(conn, mqttPingReq, 0, nil); err != nil {
+			return
+		}
+	}
+}
+
+// disconnect drops the connection and fails every publish still waiting on it
+func (c *mqttClient) disconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *mqttClient) write(conn net.Conn, kind byte, flags byte, body []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return writeMQTTPacket(conn, kind, flags, body)
+}
+
+func (c *mqttClient) readLoop() error {
+	c.mutex.Lock()
+	conn := c.conn.(*bufferedConn)
+	c.mutex.Unlock()
+
+	for {
+		// the broker answers every ping, so a connection silent for longer than the keepalive is gone
+		conn.SetReadDeadline(time.Now().Add(c.keepalive * 3 / 2))
+		p, err := readMQTTPacket(conn.reader)
+		if err != nil {
+			return err
+		}
+
+		switch p.kind {
+		case mqttPublish:
+			msg, err := decodePublish(p)
+			if err != nil {
+				return err
+			}
+			if msg.qos == 1 {
+				c.write(conn, mqttPubAck, 0, encodePacketID(msg.id))
+			} else if msg.qos == 2 {
+				c.write(conn, mqttPubRec, 0, encodePacketID(msg.id))
+			}
+			c.deliver(msg)
+		case mqttPubRel:
+			id, err := decodePacketID(p)
+			if err != nil {
+				return err
+			}
+			c.write(conn, mqttPubComp, 0, encodePacketID(id))
+		case mqttPubAck, mqttPubRec, mqttPubComp, mqttSubAck:
+			id, err := decodePacketID(p)
+			if err != nil {
+				return err
+			}
+			c.mutex.Lock()
+			ch, ok := c.pending[id]
+			c.mutex.Unlock()
+			if ok {
+				ch <- p
+			}
+		case mqttPingResp:
+		default:
+			log.Debugf("Ignoring mqtt packet type %d", p.kind)
+		}
+	}
+}
+
+// deliver passes a received message to everyone waiting for it
+func (c *mqttClient) deliver(msg mqttMessage) {
+	payload := string(msg.payload)
+	log.Debugf("MQTT message on %s: %s", msg.topic, payload)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for w := range c.waiters {
+		if !topicMatches(w.topic, msg.topic) || (w.expected != nil && *w.expected != payload) {
+			continue
+		}
+		select {
+		case w.received <- payload:
+		default:
+		}
+	}
+}
+
+// request sends a packet with a new packet id and waits for the broker's reply of the given kind
+func (c *mqttClient) request(kind byte, flags byte, body func(id uint16) []byte, reply byte) (mqttPacket, error) {
+	c.mutex.Lock()
+	if c.conn == nil {
+		c.mutex.Unlock()
+		return mqttPacket{}, errMQTTNotConnected
+	}
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	id := c.nextID
+	ch := make(chan mqttPacket, 2)
+	c.pending[id] = ch
+	conn := c.conn
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+	}()
+
+	if err := c.write(conn, kind, flags, body(id)); err != nil {
+		return mqttPacket{}, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case p, ok := <-ch:
+			if !ok {
+				return mqttPacket{}, fmt.Errorf("%w: connection lost", errMQTTNotConnected)
+			}
+			if p.kind != reply {
+				continue
+			}
+			// QoS 2 needs a release before the broker completes the publish
+			if p.kind == mqttPubRec {
+				if err := c.write(conn, mqttPubRel, 0x02, encodePacketID(id)); err != nil {
+					return mqttPacket{}, err
+				}
+				reply = mqttPubComp
+				continue
+			}
+			return p, nil
+		case <-timer.C:
+			return mqttPacket{}, fmt.Errorf("no reply from mqtt broker")
+		}
+	}
+}
+
+// publish sends a message. QoS 1 and 2 wait for the broker to accept it.
+func (c *mqttClient) publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos == 0 {
+		c.mutex.Lock()
+		conn := c.conn
+		c.mutex.Unlock()
+		if conn == nil {
+			return errMQTTNotConnected
+		}
+		return c.write(conn, mqttPublish, publishFlags(0, retain), encodePublish(topic, 0, 0, payload))
+	}
+
+	reply := mqttPubAck
+	if qos == 2 {
+		reply = mqttPubRec
+	}
+	_, err := c.request(mqttPublish, publishFlags(qos, retain), func(id uint16) []byte {
+		return encodePublish(topic, id, qos, payload)
+	}, reply)
+	return err
+}
+
+// subscribe adds acknowledgement topics, which are kept across reconnects
+func (c *mqttClient) subscribe(filters []string) {
+	c.mutex.Lock()
+	var added []string
+	for _, filter := range filters {
+		if !c.subscriptions[filter] {
+			c.subscriptions[filter] = true
+			added = append(added, filter)
+		}
+	}
+	connected := c.conn != nil
+	c.mutex.Unlock()
+
+	if len(added) == 0 || !connected {
+		return
+	}
+	if err := c.sendSubscribe(added); err != nil {
+		log.Errorf("Failed to subscribe to mqtt acknowledgements %v: %v", added, err)
+	}
+}
+
+func (c *mqttClient) sendSubscribe(filters []string) error {
+	p, err := c.request(mqttSubscribe, 0x02, func(id uint16) []byte {
+		return encodeSubscribe(id, filters, 0)
+	}, mqttSubAck)
+	if err != nil {
+		return err
+	}
+
+	for i, code := range p.body[2:] {
+		if code == 0x80 && i < len(filters) {
+			return fmt.Errorf("broker refused subscription to %s", filters[i])
+		}
+	}
+	log.Debugf("Subscribed to mqtt topics %s", strings.Join(filters, ", "))
+	return nil
+}
+
+// expect starts waiting for a message on a topic. It must be called before the message that causes it is published.
+func (c *mqttClient) expect(topic string, expected *string) *mqttWaiter {
+	w := &mqttWaiter{topic: topic, expected: expected, received: make(chan string, 1)}
+
+	c.mutex.Lock()
+	c.waiters[w] = true
+	c.mutex.Unlock()
+
+	return w
+}
+
+// wait returns the payload of the expected message, or false if it does not arrive in time
+func (c *mqttClient) wait(w *mqttWaiter, timeout time.Duration) (string, bool) {
+	defer func() {
+		c.mutex.Lock()
+		delete(c.waiters, w)
+		c.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case payload := <-w.received:
+		return payload, true
+	case <-timer.C:
+		return "", false
+	}
+}
+
+// connected reports whether messages can currently be published
+func (c *mqttClient) connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.conn != nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reportCue is everything that happened to one cue over the logs in a report
+type reportCue struct {
+	Cue      string
+	Fired    int
+	Failed   int // times at least one output failed
+	First    time.Time
+	Last     time.Time
+	Sources  []string
+	Outputs  []*reportOutput
+	Errors   []string
+	outputs  map[string]*reportOutput
+	sources  map[string]bool
+	errorSet map[string]bool
+}
+
+// reportOutput is one output of a cue over the logs in a report
+type reportOutput struct {
+	Output  string
+	Action  string
+	OK      int
+	Failed  int
+	total   time.Duration
+	Slowest time.Duration
+}
+
+func (o *reportOutput) Average() time.Duration {
+	if o.OK+o.Failed == 0 {
+		return 0
+	}
+	return o.total / time.Duration(o.OK+o.Failed)
+}
+
+func (o *reportOutput) String() string {
+	return fmt.Sprintf("%s %d/%d ok (avg %v, max %v)", o.Output, o.OK, o.OK+o.Failed, o.Average().Round(time.Millisecond), o.Slowest.Round(time.Millisecond))
+}
+
+// showReport is the summary of a night's event log
+type showReport struct {
+	From    time.Time
+	To      time.Time
+	Fired   int
+	Failed  int
+	Cues    []*reportCue
+	Created time.Time
+}
+
+// readEventLog adds every cue in an event log to the report, skipping cues from before since and cues
+// that osc-map had nothing to do for unless all is set. Lines that cannot be read, such as one cut short
+// when osc-map was stopped, are skipped with a warning.
+func (r *showReport) readEventLog(name string, in io.Reader, since time.Time, all bool) error {
+	cues := make(map[string]*reportCue)
+	for _, c := range r.Cues {
+		cues[c.Cue] = c
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record CueRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Warnf("%s: skipping line %d: %v", name, line, err)
+			continue
+		}
+		if record.Time.Before(since) || (!all && len(record.Outputs) == 0) {
+			continue
+		}
+
+		c, ok := cues[record.Cue]
+		if !ok {
+			c = &reportCue{
+				Cue:      record.Cue,
+				First:    record.Time,
+				outputs:  make(map[string]*reportOutput),
+				sources:  make(map[string]bool),
+				errorSet: make(map[string]bool),
+			}
+			cues[record.Cue] = c
+			r.Cues = append(r.Cues, c)
+		}
+
+		c.Fired++
+		r.Fired++
+		if record.Time.Before(c.First) {
+			c.First = record.Time
+		}
+		if record.Time.After(c.Last) {
+			c.Last = record.Time
+		}
+		if r.From.IsZero() || record.Time.Before(r.From) {
+			r.From = record.Time
+		}
+		if record.Time.After(r.To) {
+			r.To = record.Time
+		}
+		if !c.sources[record.Source] {
+			c.sources[record.Source] = true
+			c.Sources = append(c.Sources, record.Source)
+		}
+
+		failed := false
+		for _, result := range record.Outputs {
+			o, ok := c.outputs[result.Output]
+			if !ok {
+				o = &reportOutput{Output: result.Output, Action: result.Action}
+				c.outputs[result.Output] = o
+				c.Outputs = append(c.Outputs, o)
+			}
+			o.total += result.Duration
+			if result.Duration > o.Slowest {
+				o.Slowest = result.Duration
+			}
+			if result.Status == "ok" {
+				o.OK++
+				continue
+			}
+			o.Failed++
+			failed = true
+			if result.Error != "" && !c.errorSet[result.Error] {
+				c.errorSet[result.Error] = true
+				c.Errors = append(c.Errors, result.Error)
+			}
+		}
+		if failed {
+			c.Failed++
+			r.Failed++
+		}
+	}
+
+	sort.Slice(r.Cues, func(i, j int) bool {
+		return cueLess(r.Cues[i].Cue, r.Cues[j].Cue)
+	})
+	return scanner.Err()
+}
+
+// writeCSV writes one row for each cue
+func (r *showReport) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"cue", "fired", "failed", "first", "last", "sources", "outputs", "errors"})
+	for _, c := range r.Cues {
+		outputs := make([]string, len(c.Outputs))
+		for i, o := range c.Outputs {
+			outputs[i] = o.String()
+		}
+		out.Write([]string{
+			c.Cue,
+			fmt.Sprint(c.Fired),
+			fmt.Sprint(c.Failed),
+			c.First.Format(time.RFC3339),
+			c.Last.Format(time.RFC3339),
+			strings.Join(c.Sources, " "),
+			strings.Join(outputs, "; "),
+			strings.Join(c.Errors, " | "),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>OSC-Map report {{.From.Format "2006-01-02"}}</title>
+<style>
+  body { font: 14px system-ui, sans-serif; margin: 2em; color: #222; }
+  table { border-collapse: collapse; width: 100%; }
+  th, td { text-align: left; padding: .3em .5em; border-bottom: 1px solid #ddd; vertical-align: top; }
+  th { background: #f3f3f3; }
+  tr.failed td { background: #fde8e8; }
+  .ok { color: #1a7f37; }
+  .error { color: #c62828; font-weight: bold; }
+  .muted { color: #777; font-size: .9em; }
+</style>
+</head>
+<body>
+<h1>OSC-Map report</h1>
+<p>{{.Fired}} cues fired from {{.From.Format "Jan 2 15:04:05"}} to {{.To.Format "Jan 2 15:04:05"}}, {{if .Failed}}<span class="error">{{.Failed}} with errors</span>{{else}}<span class="ok">no errors</span>{{end}}.</p>
+<table>
+<tr><th>Cue</th><th>Fired</th><th>First</th><th>Last</th><th>From</th><th>Outputs</th><th>Errors</th></tr>
+{{range .Cues}}<tr{{if .Failed}} class="failed"{{end}}>
+  <td>{{.Cue}}</td>
+  <td>{{.Fired}}{{if .Failed}} <span class="error">({{.Failed}} failed)</span>{{end}}</td>
+  <td>{{.First.Format "15:04:05"}}</td>
+  <td>{{.Last.Format "15:04:05"}}</td>
+  <td>{{range .Sources}}{{.}} {{end}}</td>
+  <td>{{range .Outputs}}<div><span class="{{if .Failed}}error{{else}}ok{{end}}">{{.String}}</span> <span class="muted">{{.Action}}</span></div>{{end}}</td>
+  <td>{{range .Errors}}<div>{{.}}</div>{{end}}</td>
+</tr>
+{{end}}</table>
+<p class="muted">Created {{.Created.Format "2006-01-02 15:04:05"}}</p>
+</body>
+</html>
+`))
+
+// writeHTML writes the report as a page for the stage manager
+func (r *showReport) writeHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// runReport is osc-map report, which summarizes event logs by cue so that misfires stand out
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("osc-map report", flag.ContinueOnError)
+	format := flags.String("format", "", "csv or html, taken from the -o file's extension by default, otherwise csv")
+	output := flags.String("o", "", "file to write the report to, standard output by default")
+	since := flags.String("since", "", "only include cues from this time on, e.g. 2025-03-14 or 2025-03-14T18:30")
+	all := flags.Bool("all", false, "include cues that osc-map had nothing to do for")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: osc-map report [-format csv|html] [-o file] [-since time] [-all] event-log...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no event log given")
+	}
+
+	var from time.Time
+	if *since != "" {
+		var err error
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+			if from, err = time.ParseInLocation(layout, *since, time.Local); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("invalid -since %q, use a date like 2025-03-14 or 2025-03-14T18:30", *since)
+		}
+	}
+
+	if *format == "" {
+		*format = "csv"
+		if ext := strings.ToLower(filepath.Ext(*output)); ext == ".html" || ext == ".htm" {
+			*format = "html"
+		}
+	}
+	if *format != "csv" && *format != "html" {
+		return fmt.Errorf("unknown format %q, use csv or html", *format)
+	}
+
+	report := &showReport{Created: time.Now()}
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = report.readEventLog(path, file, from, *all)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "html" {
+		return report.writeHTML(w)
+	}
+	return report.writeCSV(w)
+}
@@ -6,17 +6,18 @@ import (
 )
 
 // sendMidiOut sends a MIDI message to the midi out that configured in the config
-func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) {
+func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) error {
 	if m.midiOut == nil {
-		return
+		return nil
 	}
 
-	mc, ok := m.controlMap[cueNumber]
+	controlMap := m.settings().controlMap
+	mc, ok := controlMap[cueNumber]
 	if !ok {
-		mc, ok = m.controlMap[cueInteger]
+		mc, ok = controlMap[cueInteger]
 		if !ok {
 			log.Debugf("No soundboard interface command for cue[%v]", cueNumber)
-			return
+			return nil
 		}
 	}
 
@@ -27,21 +28,19 @@ func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) {
 	faderVal := mc.faderVal
 
 	if soundCue == 0 && len(muteCue) == 0 && len(unmuteCue) == 0 && len(faderCue) == 0 {
-		return
+		return nil
 	}
 
 	if soundCue != 0 {
 		mm := midi.ProgramChange(m.midiOutChannel, soundCue-1)
 		out, err := midi.SendTo(*m.midiOut)
 		if err != nil {
-			log.Errorf("Failed to get midi send function: %v", err)
-			return
+			return cueError("Failed to get midi send function: %v", err)
 		}
 
 		err = out(mm)
 		if err != nil {
-			log.Errorf("Failed to send midi program change message to [%v]: %v", m.midiOut, err)
-			return
+			return cueError("Failed to send midi program change message to [%v]: %v", m.midiOut, err)
 		}
 
 		log.Infof("Sent program change %v to midi out", soundCue)
@@ -53,13 +52,12 @@ func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) {
 
 			out, err := midi.SendTo(*m.midiOut)
 			if err != nil {
-				log.Errorf("Failed to get midi send function: %v", err)
+				return cueError("Failed to get midi send function: %v", err)
 			}
 
 			err = out(mm)
 			if err != nil {
-				log.Errorf("Failed to send midi note message to [%v]: %v", m.midiOut, err)
-				return
+				return cueError("Failed to send midi note message to [%v]: %v", m.midiOut, err)
 			}
 		}
 
@@ -72,13 +70,12 @@ func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) {
 
 			out, err := midi.SendTo(*m.midiOut)
 			if err != nil {
-				log.Errorf("Failed to get midi send function: %v", err)
+				return cueError("Failed to get midi send function: %v", err)
 			}
 
 			err = out(mm)
 			if err != nil {
-				log.Errorf("Failed to send midi note message to [%v]: %v", m.midiOut, err)
-				return
+				return cueError("Failed to send midi note message to [%v]: %v", m.midiOut, err)
 			}
 		}
 
@@ -99,13 +96,12 @@ func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) {
 
 			out, err := midi.SendTo(*m.midiOut)
 			if err != nil {
-				log.Errorf("Failed to get midi send function: %v", err)
+				return cueError("Failed to get midi send function: %v", err)
 			}
 
 			err = out(mm)
 			if err != nil {
-				log.Errorf("Failed to send midi control change to [%v]: %v", m.midiOut, err)
-				return
+				return cueError("Failed to send midi control change to [%v]: %v", m.midiOut, err)
 			}
 		}
 
@@ -117,15 +113,16 @@ func (m *OSCMap) sendMidiOut(cueNumber string, cueInteger string) {
 
 		out, err := midi.SendTo(*m.qlabOut)
 		if err != nil {
-			log.Errorf("Failed to get midi send function: %v", err)
+			return cueError("Failed to get midi send function: %v", err)
 		}
 
 		err = out(mm)
 		if err != nil {
-			log.Errorf("Failed to send midi program change message to [%v]: %v", m.qlabOut, err)
-			return
+			return cueError("Failed to send midi program change message to [%v]: %v", m.qlabOut, err)
 		}
 
 		log.Infof("Sent program change %v to qlab", soundCue)
 	}
+
+	return nil
 }
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal reports whether the file descriptor is a terminal
+func isTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), ioctlReadTermios)
+	return err == nil
+}
+
+// makeRaw turns off line editing and echo on the terminal so that keys such as tab can be read as they are
+// pressed. Output processing is left on, so log lines still end properly. The returned function restores it.
+func makeRaw(fd uintptr) (func(), error) {
+	termios, err := unix.IoctlGetTermios(int(fd), ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	old := *termios
+
+	termios.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Iflag &^= unix.ICRNL | unix.IXON
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(fd), ioctlWriteTermios, termios); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		unix.IoctlSetTermios(int(fd), ioctlWriteTermios, &old)
+	}, nil
+}
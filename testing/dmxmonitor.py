@@ -0,0 +1,69 @@
+# A stand-in for the house light nodes to test osc-map's sACN and Art-Net output without any hardware.
+# Prints every channel that changes, with the universe and the source.
+#
+# python dmxmonitor.py sacn 1 2
+# python dmxmonitor.py artnet
+#
+# For sACN, pass the universes to join. Then point osc-map at it with:
+#   outputs:
+#     dmx:
+#       protocol: sacn
+# or, for Art-Net on the same machine:
+#   outputs:
+#     dmx:
+#       protocol: artnet
+#       destination: 127.0.0.1
+
+import socket
+import struct
+import sys
+
+SACN_PORT = 5568
+ARTNET_PORT = 6454
+
+protocol = sys.argv[1] if len(sys.argv) > 1 else "sacn"
+universes = [int(u) for u in sys.argv[2:]] or [1]
+
+sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM, socket.IPPROTO_UDP)
+sock.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+
+if protocol == "sacn":
+    sock.bind(("", SACN_PORT))
+    for u in universes:
+        group = socket.inet_aton(f"239.255.{u >> 8}.{u & 0xff}")
+        sock.setsockopt(socket.IPPROTO_IP, socket.IP_ADD_MEMBERSHIP, group + socket.inet_aton("0.0.0.0"))
+else:
+    sock.bind(("", ARTNET_PORT))
+
+last = {}
+
+
+def decode(packet):
+    if protocol == "sacn":
+        if len(packet) < 126 or packet[4:16] != b"ASC-E1.17\x00\x00\x00" or packet[125] != 0:
+            return None
+        name = packet[44:108].split(b"\x00")[0].decode(errors="replace")
+        universe = struct.unpack(">H", packet[113:115])[0]
+        slots = struct.unpack(">H", packet[123:125])[0] - 1
+        return f"{name} priority {packet[108]}", universe, packet[126:126 + slots]
+
+    if len(packet) < 18 or packet[0:8] != b"Art-Net\x00" or struct.unpack("<H", packet[8:10])[0] != 0x5000:
+        return None
+    universe = struct.unpack("<H", packet[14:16])[0] & 0x7fff
+    length = struct.unpack(">H", packet[16:18])[0]
+    return "art-net", universe, packet[18:18 + length]
+
+
+while True:
+    packet, addr = sock.recvfrom(1500)
+    decoded = decode(packet)
+    if decoded is None:
+        continue
+
+    source, universe, data = decoded
+    key = (addr[0], universe)
+    previous = last.get(key, bytes(len(data)))
+    changes = [f"{i + 1}={v}" for i, v in enumerate(data) if i >= len(previous) or previous[i] != v]
+    if changes:
+        print(f"{addr[0]} ({source}) universe {universe}: {' '.join(changes)}")
+    last[key] = data
@@ -0,0 +1,113 @@
+# A stand-in for Home Assistant to test osc-map house light commands without the real server.
+# Serves both the REST service API and the websocket API, and prints every command it receives.
+#
+# pip install aiohttp
+# python fakeha.py
+#
+# Then point osc-map at it with:
+#   outputs:
+#     home-assistant:
+#       url: http://localhost:8123
+#       websocket: true
+# and set HAKEY=test before starting osc-map.
+
+import json
+from datetime import datetime, timezone
+
+from aiohttp import web, WSMsgType
+
+TOKEN = "test"
+NUM_LIGHTS = 15
+
+states = {
+    f"light.house_light_{i}": {
+        "entity_id": f"light.house_light_{i}",
+        "state": "off",
+        "attributes": {"rgbw_color": [0, 0, 0, 0], "effect": "Light Board Control"},
+        "last_changed": datetime.now(timezone.utc).isoformat(),
+    }
+    for i in range(1, NUM_LIGHTS + 1)
+}
+subscriptions = set()
+
+
+def apply_service(domain, service, data):
+    print(f"{domain}/{service} {json.dumps(data)}")
+    entity_id = data.get("entity_id")
+    if domain != "light" or entity_id not in states:
+        return None
+
+    state = states[entity_id]
+    state["state"] = "off" if service == "turn_off" else "on"
+    for key in ("rgbw_color", "rgb_color", "color_temp_kelvin", "brightness", "effect"):
+        if key in data:
+            state["attributes"][key] = data[key]
+    state["last_changed"] = datetime.now(timezone.utc).isoformat()
+    return state
+
+
+async def broadcast(state, subscriptions):
+    for ws, sub_id in list(subscriptions):
+        await ws.send_json({
+            "id": sub_id,
+            "type": "event",
+            "event": {
+                "event_type": "state_changed",
+                "data": {"entity_id": state["entity_id"], "new_state": state},
+            },
+        })
+
+
+async def rest_service(request):
+    if request.headers.get("Authorization") != f"Bearer {TOKEN}":
+        return web.Response(status=401, text="401: Unauthorized")
+    data = await request.json()
+    state = apply_service(request.match_info["domain"], request.match_info["service"], data)
+    if state:
+        await broadcast(state, subscriptions)
+    return web.json_response([state] if state else [])
+
+
+async def websocket(request):
+    ws = web.WebSocketResponse()
+    await ws.prepare(request)
+
+    await ws.send_json({"type": "auth_required", "ha_version": "fake"})
+    msg = await ws.receive_json()
+    if msg.get("access_token") != TOKEN:
+        await ws.send_json({"type": "auth_invalid", "message": "Invalid access token"})
+        await ws.close()
+        return ws
+    await ws.send_json({"type": "auth_ok", "ha_version": "fake"})
+
+    async for msg in ws:
+        if msg.type != WSMsgType.TEXT:
+            continue
+        msg = json.loads(msg.data)
+        result = {"id": msg["id"], "type": "result", "success": True, "result": None}
+
+        if msg["type"] == "subscribe_events":
+            subscriptions.add((ws, msg["id"]))
+        elif msg["type"] == "get_states":
+            result["result"] = list(states.values())
+        elif msg["type"] == "call_service":
+            state = apply_service(msg["domain"], msg["service"], msg.get("service_data", {}))
+            await ws.send_json(result)
+            if state:
+                await broadcast(state, subscriptions)
+            continue
+        else:
+            result["success"] = False
+            result["error"] = {"code": "unknown_command", "message": "Unknown command."}
+
+        await ws.send_json(result)
+
+    for sub in [s for s in subscriptions if s[0] is ws]:
+        subscriptions.discard(sub)
+    return ws
+
+
+app = web.Application()
+app.router.add_post("/api/services/{domain}/{service}", rest_service)
+app.router.add_get("/api/websocket", websocket)
+web.run_app(app, port=8123)
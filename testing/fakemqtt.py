@@ -0,0 +1,135 @@
+# A stand-in MQTT broker to test osc-map's mqtt output without installing one.
+# Prints every message it receives and passes it on to any subscribers, handling QoS 0-2 and retained messages.
+#
+# python fakemqtt.py
+# python fakemqtt.py --port 1884 --echo cmnd=stat
+#
+# --echo replies to every message on the same topic with the first text replaced by the second, like a
+# Tasmota device reporting its state, so that acknowledgements can be tested. Then point osc-map at it with:
+#   outputs:
+#     mqtt:
+#       broker: tcp://127.0.0.1:1883
+
+import argparse
+import asyncio
+import struct
+
+CONNECT, CONNACK, PUBLISH, PUBACK, PUBREC, PUBREL, PUBCOMP = 1, 2, 3, 4, 5, 6, 7
+SUBSCRIBE, SUBACK, UNSUBSCRIBE, UNSUBACK, PINGREQ, PINGRESP, DISCONNECT = 8, 9, 10, 11, 12, 13, 14
+
+parser = argparse.ArgumentParser()
+parser.add_argument("--port", type=int, default=1883)
+parser.add_argument("--echo", help="reply to messages on a topic with this replacement, e.g. cmnd=stat")
+args = parser.parse_args()
+
+clients = {}
+retained = {}
+
+
+def matches(topic_filter, topic):
+    f, t = topic_filter.split("/"), topic.split("/")
+    for i, level in enumerate(f):
+        if level == "#":
+            return True
+        if i >= len(t) or (level != "+" and level != t[i]):
+            return False
+    return len(f) == len(t)
+
+
+def packet(kind, flags, body):
+    header = bytes([kind << 4 | flags])
+    remaining = len(body)
+    while True:
+        b = remaining % 128
+        remaining //= 128
+        header += bytes([b | 0x80 if remaining else b])
+        if not remaining:
+            return header + body
+
+
+def string(s):
+    b = s.encode()
+    return struct.pack(">H", len(b)) + b
+
+
+async def read_packet(reader):
+    first = (await reader.readexactly(1))[0]
+    remaining, multiplier = 0, 1
+    while True:
+        b = (await reader.readexactly(1))[0]
+        remaining += (b & 0x7F) * multiplier
+        multiplier *= 128
+        if not b & 0x80:
+            break
+    return first >> 4, first & 0x0F, await reader.readexactly(remaining)
+
+
+def publish(topic, payload, retain=False):
+    if retain:
+        retained[topic] = payload
+    for writer, filters in clients.items():
+        if any(matches(f, topic) for f in filters):
+            writer.write(packet(PUBLISH, 1 if retain else 0, string(topic) + payload))
+
+
+async def handle(reader, writer):
+    name = "?"
+    try:
+        while True:
+            kind, flags, body = await read_packet(reader)
+            if kind == CONNECT:
+                n = struct.unpack(">H", body[10:12])[0]
+                name = body[12 : 12 + n].decode()
+                clients[writer] = set()
+                print(f"{name} connected")
+                writer.write(packet(CONNACK, 0, b"\x00\x00"))
+            elif kind == SUBSCRIBE:
+                packet_id, rest, codes = body[:2], body[2:], b""
+                while rest:
+                    n = struct.unpack(">H", rest[:2])[0]
+                    topic_filter = rest[2 : 2 + n].decode()
+                    rest = rest[3 + n :]
+                    clients[writer].add(topic_filter)
+                    codes += b"\x00"
+                    print(f"{name} subscribed to {topic_filter}")
+                writer.write(packet(SUBACK, 0, packet_id + codes))
+                for topic, payload in retained.items():
+                    if any(matches(f, topic) for f in clients[writer]):
+                        writer.write(packet(PUBLISH, 1, string(topic) + payload))
+            elif kind == UNSUBSCRIBE:
+                writer.write(packet(UNSUBACK, 0, body[:2]))
+            elif kind == PUBLISH:
+                qos, retain = (flags >> 1) & 3, bool(flags & 1)
+                n = struct.unpack(">H", body[:2])[0]
+                topic, rest = body[2 : 2 + n].decode(), body[2 + n :]
+                if qos:
+                    packet_id, rest = rest[:2], rest[2:]
+                    writer.write(packet(PUBACK if qos == 1 else PUBREC, 0, packet_id))
+                print(f"{name} -> {topic} (qos {qos}{', retained' if retain else ''}): {rest.decode(errors='replace')}")
+                publish(topic, rest, retain)
+                if args.echo:
+                    old, new = args.echo.split("=", 1)
+                    if old in topic:
+                        publish(topic.replace(old, new, 1), rest)
+            elif kind == PUBREL:
+                writer.write(packet(PUBCOMP, 0, body[:2]))
+            elif kind == PINGREQ:
+                writer.write(packet(PINGRESP, 0, b""))
+            elif kind == DISCONNECT:
+                break
+            await writer.drain()
+    except (asyncio.IncompleteReadError, ConnectionError):
+        pass
+    clients.pop(writer, None)
+    writer.close()
+    print(f"{name} disconnected")
+
+
+async def main():
+    server = await asyncio.start_server(handle, "0.0.0.0", args.port)
+    print(f"Listening for mqtt on port {args.port}")
+    async with server:
+        await server.serve_forever()
+
+
+asyncio.run(main())
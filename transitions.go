@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// transitionMode is how a house light gets from its current state to a cue's color
+type transitionMode string
+
+const (
+	TransitionCrossfade      transitionMode = "crossfade"
+	TransitionBlackoutFirst  transitionMode = "blackout-first"
+	TransitionBrightnessOnly transitionMode = "brightness-only"
+	TransitionRelease        transitionMode = "release"
+)
+
+// parseTransitionMode validates a transition mode from the config. Without one, the mode follows the
+// effect as it always has: "Light Board Control" fades to the color and hands back, anything else blacks out first.
+func parseTransitionMode(mode string, effect string) (transitionMode, error) {
+	if mode == "" {
+		if effect == "Light Board Control" {
+			return TransitionRelease, nil
+		}
+		return TransitionBlackoutFirst, nil
+	}
+
+	switch m := transitionMode(strings.ToLower(mode)); m {
+	case TransitionCrossfade, TransitionBlackoutFirst, TransitionBrightnessOnly, TransitionRelease:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown transition mode %q, use crossfade, blackout-first, brightness-only or release", mode)
+}
+
+// steps returns the requests that carry out the command on a light. A custom effect is not included, as it is run
+// separately once the light is ready for it.
+func (c lightCommand) steps(customEffect bool) []lightStep {
+	var steps []lightStep
+	if c.mode == TransitionBlackoutFirst {
+		steps = append(steps, lightStep{rgbw: []int{0, 0, 0, 0}, effect: "None"})
+	}
+	if customEffect {
+		return steps
+	}
+
+	color := lightStep{
+		rgbw:           c.rgbw,
+		transition:     c.transition,
+		effect:         c.effect,
+		brightnessOnly: c.mode == TransitionBrightnessOnly,
+	}
+
+	// the light keeps the color until the lightboard takes it back
+	if c.mode == TransitionRelease || c.effect == "Light Board Control" {
+		if c.effect == "Light Board Control" {
+			color.effect = "None"
+		}
+		color.hold = time.Duration(c.hold * float32(time.Second))
+		return append(steps, color, lightStep{rgbw: c.rgbw, effect: "Light Board Control"})
+	}
+
+	return append(steps, color)
+}
@@ -5,16 +5,30 @@ import "net"
 type conf struct {
 	OSCIn confOSC `yaml:"oscIn"`
 
-	Outputs           confOutputs      `yaml:"outputs"`
-	ControlCueMapping []confCueMapping `yaml:"control-cue-mapping"`
+	Outputs           confOutputs                 `yaml:"outputs"`
+	AllStop           confAllStop                 `yaml:"all-stop"`
+	Web               confWeb                     `yaml:"web"`
+	Log               confLog                     `yaml:"log"`
+	Fixtures          []confFixture               `yaml:"fixtures"`
+	Groups            map[string][]string         `yaml:"groups"`
+	Looks             map[string][]confLookEntry  `yaml:"looks"`
+	CustomEffects     map[string]confCustomEffect `yaml:"custom-effects"`
+	ControlCueMapping []confCueMapping            `yaml:"control-cue-mapping"`
 }
 
 type confOutputs struct {
-	OSCOut           confOSC          `yaml:"oscOut"`
-	MIDIPC           confOutputMIDIPC `yaml:"midi-pc"`
-	Qlab             bool             `yaml:"qlab"`
-	KeyboardCommands bool             `yaml:"keyboard-commands"`
-	AudioFiles       bool             `yaml:"audio-files"`
+	OSCOut           confOSC           `yaml:"oscOut"`
+	MIDIPC           confOutputMIDIPC  `yaml:"midi-pc"`
+	Qlab             bool              `yaml:"qlab"`
+	KeyboardCommands bool              `yaml:"keyboard-commands"`
+	KeyboardBackend  string            `yaml:"keyboard-backend"`
+	KeyboardWindow   string            `yaml:"keyboard-window"`
+	AudioFiles       bool              `yaml:"audio-files"`
+	Audio            confOutputAudio   `yaml:"audio"`
+	OSCStatus        confOSCStatus     `yaml:"oscStatus"`
+	HomeAssistant    confHomeAssistant `yaml:"home-assistant"`
+	DMX              confDMX           `yaml:"dmx"`
+	MQTT             confMQTT          `yaml:"mqtt"`
 }
 
 type confOSC struct {
@@ -22,24 +36,178 @@ type confOSC struct {
 	Port int    `yaml:"port"`
 }
 
+type confOSCStatus struct {
+	IP       net.IP  `yaml:"ip"`
+	Port     int     `yaml:"port"`
+	Interval float32 `yaml:"interval"`
+}
+
+type confHomeAssistant struct {
+	URL                string  `yaml:"url"`
+	TokenEnv           string  `yaml:"token-env"`
+	TokenFile          string  `yaml:"token-file"`
+	Timeout            float32 `yaml:"timeout"`
+	InsecureSkipVerify bool    `yaml:"insecure-skip-verify"`
+	CAFile             string  `yaml:"ca-file"`
+	WebSocket          bool    `yaml:"websocket"`
+	MaxRate            float32 `yaml:"max-rate"`
+}
+
+type confDMX struct {
+	Protocol    string  `yaml:"protocol"`
+	Destination string  `yaml:"destination"`
+	Port        int     `yaml:"port"`
+	SourceName  string  `yaml:"source-name"`
+	Priority    int     `yaml:"priority"`
+	Merge       string  `yaml:"merge"`
+	Listen      bool    `yaml:"listen"`
+	Rate        float32 `yaml:"rate"`
+}
+
+type confMQTT struct {
+	Broker             string  `yaml:"broker"`
+	ClientID           string  `yaml:"client-id"`
+	Username           string  `yaml:"username"`
+	PasswordEnv        string  `yaml:"password-env"`
+	Keepalive          float32 `yaml:"keepalive"`
+	AckTimeout         float32 `yaml:"ack-timeout"`
+	InsecureSkipVerify bool    `yaml:"insecure-skip-verify"`
+}
+
 type confOutputMIDIPC struct {
 	Name    string `yaml:"name"`
 	Channel uint8  `yaml:"channel"`
 }
 
+type confOutputAudio struct {
+	SampleRate      int    `yaml:"sample-rate"`
+	BufferSize      int    `yaml:"buffer-size"`
+	ResampleQuality int    `yaml:"resample-quality"`
+	ResampleCache   bool   `yaml:"resample-cache"`
+	CacheDir        string `yaml:"cache-dir"`
+}
+
+type confLog struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	File   string `yaml:"file"`
+	Events string `yaml:"events"`
+}
+
+type confWeb struct {
+	IP       net.IP `yaml:"ip"`
+	Port     int    `yaml:"port"`
+	TokenEnv string `yaml:"token-env"`
+}
+
+type confAllStop struct {
+	OSCAddress         string  `yaml:"osc-address"`
+	Hotkey             string  `yaml:"hotkey"`
+	Fade               float32 `yaml:"fade"`
+	ReleaseHouseLights bool    `yaml:"release-houselights"`
+}
+
+type confFixture struct {
+	Number  int    `yaml:"number"`
+	Name    string `yaml:"name"`
+	Entity  string `yaml:"entity"`
+	Mode    string `yaml:"mode"`
+	Effects *bool  `yaml:"effects"`
+	Row     int    `yaml:"row"`
+	Column  int    `yaml:"column"`
+
+	Universe *int   `yaml:"universe"`
+	Address  int    `yaml:"address"`
+	Channels string `yaml:"channels"`
+}
+
+type confLookEntry struct {
+	HouseLights    []string  `yaml:"houselights"`
+	RGBW           colorSpec `yaml:"rgbw"`
+	Transition     float32   `yaml:"transition"`
+	Effect         string    `yaml:"effect"`
+	TransitionMode string    `yaml:"transition-mode"`
+	Hold           *float32  `yaml:"hold"`
+}
+
+type confCustomEffect struct {
+	Type        string           `yaml:"type"`
+	Colors      []colorSpec      `yaml:"colors"`
+	Steps       []confEffectStep `yaml:"steps"`
+	Interval    float32          `yaml:"interval"`
+	Transition  *float32         `yaml:"transition"`
+	Period      float32          `yaml:"period"`
+	Depth       float32          `yaml:"depth"`
+	PhaseOffset float32          `yaml:"phase-offset"`
+	Direction   string           `yaml:"direction"`
+	Scope       string           `yaml:"scope"`
+	Seed        uint64           `yaml:"seed"`
+}
+
+type confEffectStep struct {
+	RGBW       colorSpec `yaml:"rgbw"`
+	Transition float32   `yaml:"transition"`
+	Interval   float32   `yaml:"interval"`
+}
+
 type confCueMapping struct {
-	In           string    `yaml:"light"`
-	Sound        uint8     `yaml:"sound"`
-	Mute         []uint8   `yaml:"mute"`
-	Unmute       []uint8   `yaml:"unmute"`
-	FaderChannel []uint8   `yaml:"fader"`
-	FaderValue   []uint8   `yaml:"value"`
-	Keyboard     string    `yaml:"keyboard"`
-	AudioFile    string    `yaml:"file"`
-	HouseLights  []int     `yaml:"houselights"`
-	RGBWs        [][]int   `yaml:"rgbws"`
-	Transitions  []float32 `yaml:"transitions"`
-	Effects      []string  `yaml:"effects"`
+	In           string                `yaml:"light"`
+	Sound        uint8                 `yaml:"sound"`
+	Mute         []uint8               `yaml:"mute"`
+	Unmute       []uint8               `yaml:"unmute"`
+	FaderChannel []uint8               `yaml:"fader"`
+	FaderValue   []uint8               `yaml:"value"`
+	Keyboard     keyboardSpec          `yaml:"keyboard"`
+	AudioFile    string                `yaml:"file"`
+	AudioLevel   float64               `yaml:"level"`
+	AudioBus     string                `yaml:"bus"`
+	HouseLights  []string              `yaml:"houselights"`
+	RGBWs        []colorSpec           `yaml:"rgbws"`
+	Transitions  []float32             `yaml:"transitions"`
+	Effects      []string              `yaml:"effects"`
+	Modes        []string              `yaml:"transition-modes"`
+	Holds        []float32             `yaml:"holds"`
+	Look         string                `yaml:"look"`
+	Restore      string                `yaml:"restore"`
+	MQTT         []confMQTTAction      `yaml:"mqtt"`
+	Exec         []confExecAction      `yaml:"exec"`
+	HTTP         []confHTTPAction      `yaml:"http"`
+	Services     []confHAServiceAction `yaml:"services"`
+}
+
+type confHAServiceAction struct {
+	Service string                 `yaml:"service"`
+	Scene   string                 `yaml:"scene"`
+	Entity  stringList             `yaml:"entity"`
+	Data    map[string]interface{} `yaml:"data"`
+}
+
+type confHTTPAction struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    httpBody          `yaml:"body"`
+	Timeout float32           `yaml:"timeout"`
+	Retries int               `yaml:"retries"`
+}
+
+type confExecAction struct {
+	Command       string            `yaml:"command"`
+	Args          []string          `yaml:"args"`
+	Env           map[string]string `yaml:"env"`
+	Dir           string            `yaml:"dir"`
+	Timeout       float32           `yaml:"timeout"`
+	Detach        bool              `yaml:"detach"`
+	KillOnAllStop bool              `yaml:"kill-on-all-stop"`
+}
+
+type confMQTTAction struct {
+	Topic      string  `yaml:"topic"`
+	Payload    string  `yaml:"payload"`
+	QoS        byte    `yaml:"qos"`
+	Retain     bool    `yaml:"retain"`
+	Ack        string  `yaml:"ack"`
+	AckPayload *string `yaml:"ack-payload"`
 }
 
 type cueMap struct {
@@ -48,12 +216,16 @@ type cueMap struct {
 	unmuteCue   []uint8
 	faderCue    []uint8
 	faderVal    []uint8
-	keyboardKey int
+	keyboard    []keyStep
 	audioFile   string
-	houseLights []int
-	rgbws       [][]int
-	transitions []float32
-	effects     []string
+	audioLevel  float64
+	audioBus    string
+	houseLights []lightCommand
+	restore     string
+	mqtt        []mqttAction
+	exec        []execAction
+	http        []httpAction
+	services    []haServiceAction
 }
 
 // Struct to represent the HomeAssistant API response
@@ -61,10 +233,13 @@ type Response struct {
 	State string `json:"state"`
 }
 
-// Struct for light control data
+// Struct for light control data, only the fields for the fixture's mode are set
 type LightRequestData struct {
-	Entity_id  string  `json:"entity_id"`
-	Rgbw_color []int   `json:"rgbw_color"`
-	Transition float32 `json:"transition"`
-	Effect     string  `json:"effect"`
+	Entity_id         string  `json:"entity_id"`
+	Rgbw_color        []int   `json:"rgbw_color,omitempty"`
+	Rgb_color         []int   `json:"rgb_color,omitempty"`
+	Color_temp_kelvin int     `json:"color_temp_kelvin,omitempty"`
+	Brightness        *int    `json:"brightness,omitempty"`
+	Transition        float32 `json:"transition"`
+	Effect            string  `json:"effect,omitempty"`
 }
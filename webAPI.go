@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const connectionCheckInterval = time.Second
+
+// CueSummary is a mapped cue and the outputs it uses
+type CueSummary struct {
+	Cue     string   `json:"cue"`
+	Outputs []string `json:"outputs"`
+}
+
+// apiError is an error sent back from the API with its status code
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// apiAction wraps an API endpoint that only accepts one method. The result is sent as JSON, or as
+// {"error": ...} if the action fails. Anything but a GET is refused from another site's page.
+func apiAction(method string, action func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use " + method})
+			return
+		}
+		if method != http.MethodGet {
+			if err := checkOrigin(r); err != nil {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+
+		result, err := action(r)
+		if err != nil {
+			status := http.StatusBadRequest
+			if e, ok := err.(*apiError); ok {
+				status = e.status
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		if result == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Debugf("Failed to send API response: %v", err)
+	}
+}
+
+// requestValue reads a value from a JSON body or a form, so the API works from scripts and from curl -d
+func requestValue(r *http.Request, name string) string {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return ""
+		}
+		if value, ok := body[name]; ok && value != nil {
+			return strings.TrimSpace(fmt.Sprint(value))
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.FormValue(name))
+}
+
+// showFile checks a config file given to /show, which must be a yaml file under the working directory
+func showFile(file string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(file))
+	if file == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid show %q, use a config file under the working directory", file)
+	}
+	if ext := strings.ToLower(filepath.Ext(clean)); ext != ".yaml" && ext != ".yml" {
+		return "", fmt.Errorf("invalid show %q, use a .yaml config file", file)
+	}
+	return clean, nil
+}
+
+// Cues returns every mapped cue in order with the outputs it uses
+func (m *OSCMap) Cues() []CueSummary {
+	controlMap := m.settings().controlMap
+	cues := cueOrder(controlMap)
+	summaries := make([]CueSummary, 0, len(cues))
+	for _, cue := range cues {
+		summary := CueSummary{Cue: cue, Outputs: []string{}}
+		for _, o := range m.cueOutputs(controlMap[cue]) {
+			summary.Outputs = append(summary.Outputs, o.name)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// watchConnections sends an event whenever a connection changes state
+func (m *OSCMap) watchConnections() {
+	states := make(map[string]string)
+	for {
+		for _, c := range m.Connections() {
+			if previous, ok := states[c.Name]; ok && previous != c.State {
+				log.Infof("%s is %s", c.Name, c.State)
+				c := c
+				events.publish(Event{Type: "connection", Connection: &c})
+			}
+			states[c.Name] = c.State
+		}
+		time.Sleep(connectionCheckInterval)
+	}
+}
+
+// addAPIRoutes adds the JSON control API to the web panel's server
+func (m *OSCMap) addAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/cues", apiAction(http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return m.Cues(), nil
+	}))
+
+	// POST /cues/{n}/fire, with ?wait=true to answer once every output has finished
+	mux.HandleFunc("/cues/", apiAction(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		cue, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/cues/"), "/fire")
+		if !ok || cue == "" || strings.Contains(cue, "/") {
+			return nil, &apiError{http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path)}
+		}
+
+		record := m.fireCue(cue, "api")
+		if r.URL.Query().Get("wait") == "true" {
+			select {
+			case <-record.done:
+			case <-r.Context().Done():
+				return nil, r.Context().Err()
+			}
+		}
+		return m.history.snapshot(record), nil
+	}))
+
+	mux.HandleFunc("/reload", apiAction(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		if err := m.reloadConfig(); err != nil {
+			return nil, err
+		}
+		return map[string]string{"show": m.show()}, nil
+	}))
+
+	mux.HandleFunc("/show", apiAction(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		file, err := showFile(requestValue(r, "show"))
+		if err != nil {
+			return nil, err
+		}
+		if err := m.switchShow(file); err != nil {
+			return nil, err
+		}
+		return map[string]string{"show": file}, nil
+	}))
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			log.Debugf("Failed to open event stream: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		ch := events.subscribe()
+		defer events.unsubscribe(ch)
+
+		// messages from the client are only read to answer pings and notice when it leaves
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case e := <-ch:
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.Errorf("Failed to encode event: %v", err)
+					continue
+				}
+				if err := conn.WriteMessage(data); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+
+	go m.watchConnections()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultWebIP keeps the panel on the booth computer unless an ip is given
+const DefaultWebIP = "127.0.0.1"
+
+const (
+	lightboardPingInterval = 10 * time.Second
+	lightboardTimeout      = 3 * lightboardPingInterval // no reply for this long and the lightboard is shown as disconnected
+)
+
+//go:embed web/panel.html
+var panelPage []byte
+
+// ConnectionStatus is the state of one of the devices osc-map talks to
+type ConnectionStatus struct {
+	Name   string `json:"name"`
+	State  string `json:"state"` // connected, disconnected or off
+	Detail string `json:"detail,omitempty"`
+}
+
+// PanelStatus is everything shown on the web panel
+type PanelStatus struct {
+	Show        string             `json:"show"`
+	Current     string             `json:"current"`
+	Next        string             `json:"next"`
+	History     []CueRecord        `json:"history"`
+	Connections []ConnectionStatus `json:"connections"`
+	Audio       []PlaybackStatus   `json:"audio"`
+	HouseLights []HouseLightStatus `json:"houselights"`
+}
+
+// lightboardSeen records a message from the lightboard, for its connection state
+func (m *OSCMap) lightboardSeen() {
+	m.lightboardLast.Store(time.Now().UnixNano())
+}
+
+// pingLightboard keeps pinging the lightboard, so the panel can tell when it goes away between cues
+func (m *OSCMap) pingLightboard() {
+	for {
+		time.Sleep(lightboardPingInterval)
+		if err := m.oscOutClient.Send(osc.NewMessage("/cs/ping", "1")); err != nil {
+			log.Debugf("Failed to ping the lightboard: %v", err)
+		}
+	}
+}
+
+// Connections returns the state of the lightboard, MIDI, QLab, Home Assistant, DMX and MQTT connections
+func (m *OSCMap) Connections() []ConnectionStatus {
+	var connections []ConnectionStatus
+
+	lightboard := ConnectionStatus{Name: "Lightboard", State: "disconnected"}
+	if last := m.lightboardLast.Load(); last != 0 {
+		seen := time.Since(time.Unix(0, last))
+		if seen < lightboardTimeout {
+			lightboard.State = "connected"
+		}
+		lightboard.Detail = fmt.Sprintf("last heard %v ago", seen.Round(time.Second))
+	}
+	connections = append(connections, lightboard)
+
+	midiOut := ConnectionStatus{Name: "MIDI", State: "off"}
+	if m.midiOut != nil {
+		midiOut.State, midiOut.Detail = "connected", fmt.Sprint(*m.midiOut)
+	}
+	connections = append(connections, midiOut)
+
+	if m.qlabOut != nil {
+		connections = append(connections, ConnectionStatus{Name: "QLab", State: "connected"})
+	}
+
+	ha := ConnectionStatus{Name: "Home Assistant", State: "off"}
+	switch {
+	case m.homeAssistant == nil:
+	case m.homeAssistant.ws == nil:
+		ha.State, ha.Detail = "connected", "REST only"
+	case m.homeAssistant.Connected():
+		ha.State, ha.Detail = "connected", "websocket"
+	default:
+		ha.State, ha.Detail = "disconnected", "websocket"
+	}
+	connections = append(connections, ha)
+
+	if m.dmx != nil {
+		dmx := ConnectionStatus{Name: "DMX", State: "connected", Detail: string(m.dmx.protocol) + ", no lightboard universes"}
+		if receiving := m.dmx.receiving(); len(receiving) != 0 {
+			dmx.Detail = fmt.Sprintf("%s, receiving universes %v", m.dmx.protocol, receiving)
+		}
+		connections = append(connections, dmx)
+	}
+
+	if m.mqtt != nil {
+		mqtt := ConnectionStatus{Name: "MQTT", State: "disconnected"}
+		if m.mqtt.connected() {
+			mqtt.State = "connected"
+		}
+		connections = append(connections, mqtt)
+	}
+
+	return connections
+}
+
+// PanelStatus returns the state shown on the web panel
+func (m *OSCMap) PanelStatus() PanelStatus {
+	status := PanelStatus{
+		Show:        m.show(),
+		History:     m.history.recent(),
+		Connections: m.Connections(),
+		Audio:       playbacks.status(),
+		HouseLights: m.HouseLightStatus(),
+	}
+	if len(status.History) != 0 {
+		status.Current = status.History[0].Cue
+	}
+	status.Next = m.nextCue(status.Current)
+	return status
+}
+
+// servePanel serves the web control panel and the API until the server fails
+func (m *OSCMap) servePanel(c confWeb) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(panelPage)
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.PanelStatus()); err != nil {
+			log.Debugf("Failed to send panel status: %v", err)
+		}
+	})
+
+	mux.HandleFunc("/fire", panelAction(func(r *http.Request) error {
+		cue := strings.TrimSpace(r.FormValue("cue"))
+		if cue == "" {
+			return fmt.Errorf("no cue given")
+		}
+		m.fireCue(cue, "web")
+		return nil
+	}))
+
+	mux.HandleFunc("/allstop", panelAction(func(r *http.Request) error {
+		go m.AllStop()
+		return nil
+	}))
+
+	mux.HandleFunc("/audio/stop", panelAction(func(r *http.Request) error {
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			return fmt.Errorf("invalid playback id %q", r.FormValue("id"))
+		}
+		go playbacks.stop(id, time.Duration(m.settings().allStop.Fade*float32(time.Second)))
+		return nil
+	}))
+
+	m.addAPIRoutes(mux)
+
+	ip := c.IP
+	if ip == nil {
+		ip = net.ParseIP(DefaultWebIP)
+	}
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(c.Port))
+
+	var handler http.Handler = mux
+	if c.TokenEnv != "" {
+		token := os.Getenv(c.TokenEnv)
+		if token == "" {
+			log.Errorf("Web panel disabled: token variable %s is not set", c.TokenEnv)
+			return
+		}
+		handler = requireToken(token, mux)
+	} else if !ip.IsLoopback() {
+		log.Warnf("The web panel on %s has no token-env, so anyone on the network can fire cues", address)
+	}
+	log.Infof("Serving the web panel on http://%s", address)
+
+	go m.pingLightboard()
+	if err := http.ListenAndServe(address, handler); err != nil {
+		log.Errorf("Web panel stopped: %v", err)
+	}
+}
+
+// panelAction wraps a button on the panel, which must be a POST from the panel itself
+func panelAction(action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := checkOrigin(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err := action(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// checkOrigin rejects requests made by another site's page in the operator's browser, which could otherwise
+// fire cues through the panel. Scripts such as curl and Companion send no Origin, so they are let through.
+func checkOrigin(r *http.Request) error {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return errors.New("cross-site requests are not allowed")
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("requests from %s are not allowed", origin)
+	}
+	return nil
+}
+
+// requireToken only lets through requests with the web token, given as an Authorization: Bearer header or,
+// for the panel page and WebSockets, which cannot set headers, as a token query value
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			given = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}